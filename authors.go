@@ -0,0 +1,160 @@
+package scanner
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	library "github.com/themooer1/audiobook-library"
+)
+
+// DefaultAuthorSeparators split artist tags like "Terry Pratchett & Neil Gaiman" or
+// "Neil Gaiman; Terry Pratchett" into their authors.  Commas aren't among them, see SplitAuthors.
+var DefaultAuthorSeparators = []string{"&", ";", " / ", " and "}
+
+// AuthorSeparator joins the names of a book's authors in its Author, like "Terry Pratchett & Neil Gaiman"
+const AuthorSeparator = " & "
+
+// Author is one of the authors of a book
+type Author struct {
+	// The author's name for display, like "Terry Pratchett"
+	Name string `json:"name"`
+	// The author's name for sorting, like "Pratchett, Terry"
+	SortName string `json:"sortName"`
+}
+
+// authorSeparatorPattern returns a pattern matching any of the separators, or of
+// DefaultAuthorSeparators if they're nil, whatever their case
+func authorSeparatorPattern(separators []string) *regexp.Regexp {
+	if separators == nil {
+		separators = DefaultAuthorSeparators
+	}
+
+	quoted := make([]string, 0, len(separators))
+	for _, separator := range separators {
+		if separator != "" {
+			quoted = append(quoted, regexp.QuoteMeta(separator))
+		}
+	}
+
+	if len(quoted) == 0 {
+		return nil
+	}
+
+	return regexp.MustCompile(`(?i)` + strings.Join(quoted, "|"))
+}
+
+// SplitAuthors splits an artist tag into its authors on the separators, or on
+// DefaultAuthorSeparators if they're nil.  Names with one comma are read as "Last, First",
+// like "Le Guin, Ursula K.", and sorted under the part before it.  In tags that also have a
+// separator, they're only read that way if either side is a single word, like "Pratchett, Terry",
+// and are otherwise split on it, like names with several commas, so "Terry Pratchett, Neil
+// Gaiman & Stephen Briggs" is three authors.  Authors named more than once are only returned the
+// first time.
+func SplitAuthors(authors string, separators []string) []Author {
+	return splitAuthors(authors, authorSeparatorPattern(separators))
+}
+
+func splitAuthors(authors string, separators *regexp.Regexp) []Author {
+	parts := []string{authors}
+	if separators != nil {
+		parts = separators.Split(authors, -1)
+	}
+
+	var split []Author
+	seen := make(map[string]struct{})
+	add := func(author Author) {
+		if author.Name == "" {
+			return
+		}
+
+		folded := strings.ToLower(author.Name)
+		if _, ok := seen[folded]; ok {
+			return
+		}
+
+		seen[folded] = struct{}{}
+		split = append(split, author)
+	}
+
+	for _, part := range parts {
+		names := strings.Split(part, ",")
+
+		switch len(names) {
+		case 1:
+			add(parseAuthor(part))
+		case 2:
+			last, first := collapseSpaces(names[0]), collapseSpaces(names[1])
+			if last == "" || first == "" {
+				add(parseAuthor(last + first))
+				continue
+			}
+
+			if len(parts) == 1 || isOneWord(last) || isOneWord(first) {
+				add(Author{Name: first + " " + last, SortName: last + ", " + first})
+				continue
+			}
+
+			add(parseAuthor(last))
+			add(parseAuthor(first))
+		default:
+			for _, name := range names {
+				add(parseAuthor(name))
+			}
+		}
+	}
+
+	return split
+}
+
+// parseAuthor returns the author with the name for display, sorted under its last word
+func parseAuthor(name string) Author {
+	name = collapseSpaces(name)
+
+	words := strings.Fields(name)
+	if len(words) < 2 {
+		return Author{Name: name, SortName: name}
+	}
+
+	last := len(words) - 1
+	return Author{Name: name, SortName: words[last] + ", " + strings.Join(words[:last], " ")}
+}
+
+func collapseSpaces(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func isOneWord(s string) bool {
+	return !strings.Contains(s, " ")
+}
+
+// joinAuthors returns the authors' names joined with AuthorSeparator
+func joinAuthors(authors []Author) string {
+	names := make([]string, len(authors))
+	for i, author := range authors {
+		names[i] = author.Name
+	}
+
+	return strings.Join(names, AuthorSeparator)
+}
+
+// AuthorBooks lists the titles of the books by one author, in order of title
+type AuthorBooks struct {
+	Author
+	Books []string `json:"books"`
+}
+
+// IndexAuthors lists the library's books by each of their authors, in order of sort name.
+// Books added by a scan name their authors in Author, see AuthorSeparator, and authors are
+// sorted under the last word of their name.
+func IndexAuthors(lib *library.AudioBookLibrary) []AuthorBooks {
+	books := make([]BookReport, 0, len(lib.AudioBooksByName))
+	for title, book := range lib.AudioBooksByName {
+		books = append(books, BookReport{Title: title, Authors: SplitAuthors(book.Author, []string{AuthorSeparator}), Added: true})
+	}
+	sort.Slice(books, func(i, j int) bool {
+		return books[i].Title < books[j].Title
+	})
+
+	return indexAuthors(books)
+}
@@ -0,0 +1,127 @@
+package scanner
+
+import (
+	"reflect"
+	"testing"
+
+	library "github.com/themooer1/audiobook-library"
+)
+
+func TestSplitAuthors(t *testing.T) {
+	pratchett := Author{Name: "Terry Pratchett", SortName: "Pratchett, Terry"}
+	gaiman := Author{Name: "Neil Gaiman", SortName: "Gaiman, Neil"}
+
+	type args struct {
+		authors    string
+		separators []string
+	}
+	tests := []struct {
+		name string
+		args args
+		want []Author
+	}{
+		{"One Author", args{"Terry Pratchett", nil}, []Author{pratchett}},
+		{"Last, First", args{"Pratchett, Terry", nil}, []Author{pratchett}},
+		{"Ampersand", args{"Terry Pratchett & Neil Gaiman", nil}, []Author{pratchett, gaiman}},
+		{"Semicolon", args{"Neil Gaiman; Terry Pratchett", nil}, []Author{gaiman, pratchett}},
+		{"And", args{"Terry Pratchett AND Neil Gaiman", nil}, []Author{pratchett, gaiman}},
+		{"Inverted Authors", args{"Pratchett, Terry; Gaiman, Neil", nil}, []Author{pratchett, gaiman}},
+		{"Last, First Initials", args{"Tolkien, J. R. R.", nil}, []Author{{Name: "J. R. R. Tolkien", SortName: "Tolkien, J. R. R."}}},
+		{"Last, First With Spaces", args{"Le Guin, Ursula K.", nil}, []Author{{Name: "Ursula K. Le Guin", SortName: "Le Guin, Ursula K."}}},
+		{"Last, First With Two Last Names", args{"García Márquez, Gabriel", nil}, []Author{{Name: "Gabriel García Márquez", SortName: "García Márquez, Gabriel"}}},
+		{"Last, First With Suffix", args{"Del Toro, Guillermo Jr.", nil}, []Author{{Name: "Guillermo Jr. Del Toro", SortName: "Del Toro, Guillermo Jr."}}},
+		{"Comma Without Separator", args{"Terry Pratchett, Neil Gaiman", nil}, []Author{{Name: "Neil Gaiman Terry Pratchett", SortName: "Terry Pratchett, Neil Gaiman"}}},
+		{"Comma With Separator", args{"Terry Pratchett, Neil Gaiman & Stephen Briggs", nil}, []Author{pratchett, gaiman, {Name: "Stephen Briggs", SortName: "Briggs, Stephen"}}},
+		{"Comma Separated", args{"Terry Pratchett, Neil Gaiman, Stephen Briggs", nil}, []Author{pratchett, gaiman, {Name: "Stephen Briggs", SortName: "Briggs, Stephen"}}},
+		{"Duplicates", args{"Terry Pratchett & Pratchett, Terry", nil}, []Author{pratchett}},
+		{"Extra Spaces", args{"  Terry   Pratchett ;; ", nil}, []Author{pratchett}},
+		{"Single Name", args{"Homer", nil}, []Author{{Name: "Homer", SortName: "Homer"}}},
+		{"Trailing Comma", args{"Homer,", nil}, []Author{{Name: "Homer", SortName: "Homer"}}},
+		{"Empty", args{"", nil}, nil},
+		{"Custom Separators", args{"Terry Pratchett + Neil Gaiman & Co", []string{"+"}}, []Author{pratchett, {Name: "Neil Gaiman & Co", SortName: "Co, Neil Gaiman &"}}},
+		{"No Separators", args{"Terry Pratchett & Neil Gaiman", []string{}}, []Author{{Name: "Terry Pratchett & Neil Gaiman", SortName: "Gaiman, Terry Pratchett & Neil"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SplitAuthors(tt.args.authors, tt.args.separators); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitAuthors() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_indexAuthors(t *testing.T) {
+	pratchett := Author{Name: "Terry Pratchett", SortName: "Pratchett, Terry"}
+	gaiman := Author{Name: "Neil Gaiman", SortName: "Gaiman, Neil"}
+
+	books := []BookReport{
+		{Title: "Good Omens", Authors: []Author{pratchett, gaiman}, Added: true},
+		{Title: "Mort", Authors: []Author{pratchett}, Added: true},
+		{Title: "Neverwhere", Authors: []Author{{Name: "neil gaiman", SortName: "gaiman, neil"}}, Added: true},
+		{Title: "Unsorted", Authors: []Author{{Name: "Nobody", SortName: "Nobody"}}},
+	}
+
+	want := []AuthorBooks{
+		{Author: gaiman, Books: []string{"Good Omens", "Neverwhere"}},
+		{Author: pratchett, Books: []string{"Good Omens", "Mort"}},
+	}
+	if got := indexAuthors(books); !reflect.DeepEqual(got, want) {
+		t.Errorf("indexAuthors() = %v, want %v", got, want)
+	}
+}
+
+func TestUnsortedBook_Authors(t *testing.T) {
+	pratchett := Author{Name: "Terry Pratchett", SortName: "Pratchett, Terry"}
+	gaiman := Author{Name: "Neil Gaiman", SortName: "Gaiman, Neil"}
+
+	// The chapters name the same authors differently
+	chapters := []RelativeAudioBookChapter{
+		{bookTitle: "Good Omens", albumArtist: "Pratchett, Terry; Gaiman, Neil", trackNum: 1, filePath: "omens/01.mp3"},
+		{bookTitle: "Good Omens", bookAuthor: "Terry Pratchett and Neil Gaiman", trackNum: 2, filePath: "omens/02.mp3"},
+	}
+
+	var unsortedLibrary UnsortedBookLibrary
+	unsortedLibrary.Initialize()
+	for _, chapter := range chapters {
+		chapter.splitAuthors(authorSeparatorPattern(nil))
+		unsortedLibrary.AddChapter(chapter)
+	}
+
+	if len(unsortedLibrary.books) != 1 {
+		t.Fatalf("Library has %d books, want 1", len(unsortedLibrary.books))
+	}
+
+	for _, book := range unsortedLibrary.books {
+		if got, want := book.Authors(), []Author{pratchett, gaiman}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Authors() = %v, want %v", got, want)
+		}
+
+		audioBook, errors := book.IntoAudioBook(SortByDiscNumber[RelativeAudioBookChapter])
+		if len(errors) > 0 {
+			t.Fatalf("IntoAudioBook() returned errors: %v", errors)
+		}
+
+		if want := "Terry Pratchett & Neil Gaiman"; audioBook.Author != want {
+			t.Errorf("IntoAudioBook() author = %q, want %q", audioBook.Author, want)
+		}
+	}
+}
+
+func TestIndexAuthors(t *testing.T) {
+	pratchett := Author{Name: "Terry Pratchett", SortName: "Pratchett, Terry"}
+	gaiman := Author{Name: "Neil Gaiman", SortName: "Gaiman, Neil"}
+
+	lib := library.AudioBookLibrary{}
+	lib.Initialize()
+	lib.Add(library.AudioBook{Title: "Mort", Author: "Terry Pratchett"})
+	lib.Add(library.AudioBook{Title: "Good Omens", Author: "Terry Pratchett & Neil Gaiman"})
+	lib.Add(library.AudioBook{Title: "Neverwhere", Author: "Neil Gaiman"})
+
+	want := []AuthorBooks{
+		{Author: gaiman, Books: []string{"Good Omens", "Neverwhere"}},
+		{Author: pratchett, Books: []string{"Good Omens", "Mort"}},
+	}
+	if got := IndexAuthors(&lib); !reflect.DeepEqual(got, want) {
+		t.Errorf("IndexAuthors() = %v, want %v", got, want)
+	}
+}
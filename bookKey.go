@@ -0,0 +1,99 @@
+package scanner
+
+import (
+	"fmt"
+	"strings"
+)
+
+// BookKey identifies the book a chapter belongs to.  Chapters with the same key are grouped
+// into the same book.  Groupers only fill in the fields they go by.
+type BookKey struct {
+	Title  string
+	Author string
+	// The directory the book's chapters are in, as a url like chapters', see GroupByDirectory
+	Directory string
+	// The MusicBrainz release id of the book, see GroupByReleaseID
+	ReleaseID string
+}
+
+func bookKeyLess(a BookKey, b BookKey) bool {
+	if a.Title != b.Title {
+		return a.Title < b.Title
+	}
+
+	if a.Author != b.Author {
+		return a.Author < b.Author
+	}
+
+	if a.Directory != b.Directory {
+		return a.Directory < b.Directory
+	}
+
+	return a.ReleaseID < b.ReleaseID
+}
+
+// TitleCollision records books that share a title.  The books are named with their
+// author, like "Dune (Frank Herbert)", so they don't replace each other in the library.
+// Books by the same author are also named with their directory, relative to its root, or
+// release id, and books that still share a title are numbered, like "Dune (Frank Herbert) #2".
+type TitleCollision struct {
+	Title   string   `json:"title"`
+	Authors []string `json:"authors"`
+}
+
+// unusedTitle returns the title, or if it's taken, the title numbered with the first ordinal
+// that isn't, like "Dune #2"
+func unusedTitle(title string, taken map[string]struct{}) string {
+	if _, ok := taken[title]; !ok {
+		return title
+	}
+
+	for n := 2; ; n++ {
+		numbered := fmt.Sprintf("%s #%d", title, n)
+		if _, ok := taken[numbered]; !ok {
+			return numbered
+		}
+	}
+}
+
+// disambiguatedTitles returns the titles to give books whose names all share a title
+func disambiguatedTitles(names []BookKey) []string {
+	titles := make([]string, len(names))
+	count := make(map[string]int)
+
+	for i, name := range names {
+		author := name.Author
+		if author == "" {
+			author = "Unknown Author"
+		}
+
+		titles[i] = fmt.Sprintf("%s (%s)", name.Title, author)
+		count[titles[i]]++
+	}
+
+	for i, name := range names {
+		if count[titles[i]] == 1 {
+			continue
+		}
+
+		var location []string
+		for _, field := range []string{name.Directory, name.ReleaseID} {
+			if field != "" {
+				location = append(location, field)
+			}
+		}
+
+		if len(location) > 0 {
+			titles[i] = fmt.Sprintf("%s, %s)", strings.TrimSuffix(titles[i], ")"), strings.Join(location, ", "))
+		}
+	}
+
+	// Books without a location, or in the same one, are numbered in order
+	taken := make(map[string]struct{}, len(titles))
+	for i := range titles {
+		titles[i] = unusedTitle(titles[i], taken)
+		taken[titles[i]] = struct{}{}
+	}
+
+	return titles
+}
@@ -0,0 +1,127 @@
+package scanner
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	library "github.com/themooer1/audiobook-library"
+)
+
+func Test_disambiguatedTitles(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []BookKey
+		want  []string
+	}{
+		{
+			"Different Authors",
+			[]BookKey{{Title: "Dune", Author: "Frank Herbert"}, {Title: "Dune", Author: "Someone Else"}},
+			[]string{"Dune (Frank Herbert)", "Dune (Someone Else)"},
+		},
+		{
+			"Unknown Author",
+			[]BookKey{{Title: "Dune", Author: "Frank Herbert"}, {Title: "Dune"}},
+			[]string{"Dune (Frank Herbert)", "Dune (Unknown Author)"},
+		},
+		{
+			"Same Author",
+			[]BookKey{
+				{Title: "Dune", Author: "Frank Herbert", Directory: "dune"},
+				{Title: "Dune", Author: "Frank Herbert", Directory: "dune (2)"},
+				{Title: "Dune", Author: "Someone Else", Directory: "notes"},
+			},
+			[]string{"Dune (Frank Herbert, dune)", "Dune (Frank Herbert, dune (2))", "Dune (Someone Else)"},
+		},
+		{
+			"Same Author Without Location",
+			[]BookKey{{Title: "Dune", Author: "Frank Herbert"}, {Title: "Dune", Author: "Frank Herbert"}, {Title: "Dune", Author: "Frank Herbert"}},
+			[]string{"Dune (Frank Herbert)", "Dune (Frank Herbert) #2", "Dune (Frank Herbert) #3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := disambiguatedTitles(tt.names); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("disambiguatedTitles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnsortedBookLibrary_GroupedBy(t *testing.T) {
+	chapters := []RelativeAudioBookChapter{
+		{title: "Dune 1", bookTitle: "Dune", bookAuthor: "Frank Herbert", trackNum: 1, filePath: "herbert/dune1.mp3"},
+		{title: "Dune 2", bookTitle: "Dune", bookAuthor: "Frank Herbert", trackNum: 2, filePath: "herbert/dune2.mp3"},
+		{title: "Dune Notes", bookTitle: "Dune", bookAuthor: "Someone Else", trackNum: 3, filePath: "notes/dune.mp3"},
+		{title: "Stories", bookTitle: "Collected Stories", bookAuthor: "Narrator", albumArtist: "Author", trackNum: 1, filePath: "stories/1.mp3"},
+		{title: "Untagged", bookTitle: "Collected Stories", trackNum: 2, filePath: "stories/2.mp3"},
+	}
+
+	type args struct {
+		grouper Grouper
+	}
+	tests := []struct {
+		name string
+		args args
+		// Chapters in each book, by title
+		want           map[string]int
+		wantCollisions []TitleCollision
+	}{
+		{
+			"By Tags",
+			args{GroupByTags},
+			map[string]int{
+				"Dune (Frank Herbert)":               2,
+				"Dune (Someone Else)":                1,
+				"Collected Stories (Author)":         1,
+				"Collected Stories (Unknown Author)": 1,
+			},
+			[]TitleCollision{
+				{Title: "Collected Stories", Authors: []string{"", "Author"}},
+				{Title: "Dune", Authors: []string{"Frank Herbert", "Someone Else"}},
+			},
+		},
+		{
+			"By Album",
+			args{GroupByAlbum},
+			map[string]int{"Dune": 3, "Collected Stories": 2},
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var unsortedLibrary UnsortedBookLibrary
+			unsortedLibrary.InitializeGroupedBy(tt.args.grouper)
+
+			for _, chapter := range chapters {
+				unsortedLibrary.AddChapter(chapter)
+			}
+
+			if got := unsortedLibrary.titleCollisions(); !reflect.DeepEqual(got, tt.wantCollisions) {
+				t.Errorf("titleCollisions() = %v, want %v", got, tt.wantCollisions)
+			}
+
+			lib := library.AudioBookLibrary{}
+			lib.Initialize()
+
+			if errors := unsortedLibrary.AddAllToAudioBookLibrary(&lib, SortByDiscNumber[RelativeAudioBookChapter]); len(errors) > 0 {
+				t.Errorf("AddAllToAudioBookLibrary() returned errors: %v", errors)
+			}
+
+			got := make(map[string]int)
+			for title, book := range lib.AudioBooksByName {
+				got[title] = len(book.Chapters)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				titles := make([]string, 0, len(got))
+				for title := range got {
+					titles = append(titles, title)
+				}
+				sort.Strings(titles)
+
+				t.Errorf("Library has books %v with chapters %v, want %v", titles, got, tt.want)
+			}
+		})
+	}
+}
@@ -2,7 +2,10 @@ package scanner
 
 import (
 	"bytes"
+	"io"
+	"io/fs"
 	"os"
+	"regexp"
 
 	"github.com/dhowden/tag"
 	"github.com/edsrzf/mmap-go"
@@ -19,9 +22,25 @@ type RelativeAudioBookChapter struct {
 	title      string
 	bookTitle  string
 	bookAuthor string
-	discNum    int
-	trackNum   int
-	filePath   string
+	// The album artist tag, which is empty in files that don't have one
+	albumArtist string
+	// The names of the authors in the album artist tag, or the artist tag if it doesn't have
+	// one, joined with AuthorSeparator once they've been split, see SplitAuthors
+	authors string
+	// The MusicBrainz release id tag, see GroupByReleaseID
+	releaseID string
+	discNum   int
+	trackNum  int
+	// The root the chapter was scanned from, see Root, and the path of its file relative to the root
+	root     string
+	filePath string
+	// The path of the file in its root's filesystem, if it isn't filePath, like in filesystems
+	// whose files have urls, see URLFS
+	name string
+	// The size of the file, or zero if it's unknown
+	fileSize int64
+	// Name of the sorter that ordered the chapter, see Named
+	sortedBy string
 }
 
 func (r *RelativeAudioBookChapter) Title() string {
@@ -36,6 +55,28 @@ func (r *RelativeAudioBookChapter) BookAuthor() string {
 	return r.bookAuthor
 }
 
+func (r *RelativeAudioBookChapter) AlbumArtist() string {
+	return r.albumArtist
+}
+
+// Authors returns the authors named by the chapter's tags, see SplitAuthors
+func (r *RelativeAudioBookChapter) Authors() []Author {
+	if r.authors == "" {
+		return splitAuthors(chapterArtist(r), authorSeparatorPattern(nil))
+	}
+
+	return SplitAuthors(r.authors, []string{AuthorSeparator})
+}
+
+// splitAuthors splits the chapter's album artist or artist tag into its authors
+func (r *RelativeAudioBookChapter) splitAuthors(separators *regexp.Regexp) {
+	r.authors = joinAuthors(splitAuthors(chapterArtist(r), separators))
+}
+
+func (r *RelativeAudioBookChapter) ReleaseID() string {
+	return r.releaseID
+}
+
 func (r *RelativeAudioBookChapter) DiscNum() int {
 	return r.discNum
 }
@@ -48,48 +89,130 @@ func (r *RelativeAudioBookChapter) FilePath() string {
 	return r.filePath
 }
 
+func (r *RelativeAudioBookChapter) FileSize() int64 {
+	return r.fileSize
+}
+
+func (r *RelativeAudioBookChapter) Root() string {
+	return r.root
+}
+
+// fsPath returns the path of the chapter's file in its root's filesystem
+func (r *RelativeAudioBookChapter) fsPath() string {
+	if r.name != "" {
+		return r.name
+	}
+
+	return r.filePath
+}
+
+// url returns where the chapter's file is, with its path joined to its root
+func (r *RelativeAudioBookChapter) url() string {
+	return chapterURL(r.root, r.filePath)
+}
+
 func fromFile(audioFilePath string) (RelativeAudioBookChapter, error) {
 
 	rawAudioFile, err := os.Open(audioFilePath)
 	if err != nil {
-		return RelativeAudioBookChapter{}, err
+		return RelativeAudioBookChapter{}, &ScanError{Path: audioFilePath, Stage: StageOpen, Err: err}
 	} else {
 		defer rawAudioFile.Close()
 	}
 
-	// MMAP the rawAudioFile to get better performance
-	// when the tag parser makes lots of small reads.
-	var audioFileMappedBytes mmap.MMap
-	audioFileMappedBytes, err = mmap.Map(rawAudioFile, mmap.RDONLY, 0)
+	return fromOpenFile(rawAudioFile, "", audioFilePath)
+}
+
+// fromFS reads the chapter stored at name in fsys.  root and filePath are recorded
+// as the chapter's location, so callers can point it outside of fsys.
+func fromFS(fsys fs.FS, name string, root string, filePath string) (RelativeAudioBookChapter, error) {
+
+	audioFile, err := fsys.Open(name)
 	if err != nil {
-		return RelativeAudioBookChapter{}, err
+		return RelativeAudioBookChapter{}, &ScanError{Path: chapterURL(root, filePath), Stage: StageOpen, Err: err}
 	} else {
-		defer audioFileMappedBytes.Unmap()
+		defer audioFile.Close()
 	}
 
-	audioFileMmappedBytesReader := bytes.NewReader(audioFileMappedBytes)
+	return fromOpenFile(audioFile, root, filePath)
+}
 
-	// bufferedAudioFile := bufio.NewReader(rawAudioFile)
-	// audioFileBytes := rawAudioFile.read
+// openAudioFileReader returns a seekable reader over the contents of audioFile.
+// The returned function releases any resources held by the reader.  Errors are
+// returned with the stage that failed.
+func openAudioFileReader(audioFile fs.File) (io.ReadSeeker, func(), ScanStage, error) {
+	noop := func() {}
+
+	switch f := audioFile.(type) {
+	case *os.File:
+		// MMAP the rawAudioFile to get better performance
+		// when the tag parser makes lots of small reads.
+		audioFileMappedBytes, err := mmap.Map(f, mmap.RDONLY, 0)
+		if err != nil {
+			return nil, noop, StageMmap, err
+		}
+
+		unmap := func() { audioFileMappedBytes.Unmap() }
+
+		return bytes.NewReader(audioFileMappedBytes), unmap, "", nil
+	case io.ReadSeeker:
+		return f, noop, "", nil
+	default:
+		// Files from some filesystems can't seek, but the tag parser needs to
+		audioFileBytes, err := io.ReadAll(f)
+		if err != nil {
+			return nil, noop, StageRead, err
+		}
+
+		return bytes.NewReader(audioFileBytes), noop, "", nil
+	}
+}
+
+func fromOpenFile(audioFile fs.File, root string, audioFilePath string) (RelativeAudioBookChapter, error) {
+
+	audioFileReader, release, stage, err := openAudioFileReader(audioFile)
+	if err != nil {
+		return RelativeAudioBookChapter{}, &ScanError{Path: chapterURL(root, audioFilePath), Stage: stage, Err: err}
+	} else {
+		defer release()
+	}
 
-	metadata, err := tag.ReadFrom(audioFileMmappedBytesReader)
+	metadata, err := tag.ReadFrom(audioFileReader)
 	if err != nil {
-		return RelativeAudioBookChapter{}, err
+		return RelativeAudioBookChapter{}, &ScanError{Path: chapterURL(root, audioFilePath), Stage: StageTag, Err: err}
 	}
 
 	title := metadata.Title()
 	bookTitle := metadata.Album()
 	bookAuthor := metadata.Artist()
+	albumArtist := metadata.AlbumArtist()
+	releaseID := musicBrainzReleaseID(metadata.Raw())
 	discNum, _ := metadata.Disc()
 	trackNum, _ := metadata.Track()
 
-	return RelativeAudioBookChapter{title, bookTitle, bookAuthor, discNum, trackNum, audioFilePath}, nil
+	var fileSize int64
+	if info, err := audioFile.Stat(); err == nil {
+		fileSize = info.Size()
+	}
+
+	return RelativeAudioBookChapter{
+		title:       title,
+		bookTitle:   bookTitle,
+		bookAuthor:  bookAuthor,
+		albumArtist: albumArtist,
+		releaseID:   releaseID,
+		discNum:     discNum,
+		trackNum:    trackNum,
+		root:        root,
+		filePath:    audioFilePath,
+		fileSize:    fileSize,
+	}, nil
 }
 
 func (r *RelativeAudioBookChapter) intoAudioBookChapter(index int) library.AudioBookChapter {
 	return library.AudioBookChapter{
 		Title: r.title,
 		Index: index,
-		Url:   r.filePath,
+		Url:   r.url(),
 	}
 }
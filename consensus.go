@@ -0,0 +1,86 @@
+package scanner
+
+import "sort"
+
+// The book fields chosen by vote, see FieldDisagreement
+const (
+	TitleField  = "title"
+	AuthorField = "author"
+)
+
+// FieldDisagreement records a book field its chapters' tags disagreed on
+type FieldDisagreement struct {
+	// TitleField or AuthorField
+	Field string `json:"field"`
+	// The value the book was given, which the most chapters had
+	Chosen string `json:"chosen"`
+	// The number of chapters with each value, including "" for chapters without one
+	Votes map[string]int `json:"votes"`
+}
+
+// consensus returns the value of field that the most chapters have, ignoring chapters without
+// one unless none have one.  Ties go to the value of the chapter with the first url.  It also
+// returns the number of chapters with each value.
+func consensus(chapters []RelativeAudioBookChapter, field func(chapter *RelativeAudioBookChapter) string) (string, map[string]int) {
+	votes := make(map[string]int)
+	// The first url with each value, which breaks ties
+	firstURLs := make(map[string]string)
+
+	for i := range chapters {
+		value, url := field(&chapters[i]), chapters[i].url()
+
+		votes[value]++
+		if first, ok := firstURLs[value]; !ok || url < first {
+			firstURLs[value] = url
+		}
+	}
+
+	values := make([]string, 0, len(votes))
+	for value := range votes {
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+
+	if len(values) == 0 {
+		return "", votes
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		if votes[values[i]] != votes[values[j]] {
+			return votes[values[i]] > votes[values[j]]
+		}
+
+		if firstURLs[values[i]] != firstURLs[values[j]] {
+			return firstURLs[values[i]] < firstURLs[values[j]]
+		}
+
+		return values[i] < values[j]
+	})
+
+	return values[0], votes
+}
+
+func chapterTitle(chapter *RelativeAudioBookChapter) string {
+	return chapter.bookTitle
+}
+
+// disagreements returns the fields of the book its chapters disagreed on, in order of field
+func (u *UnsortedBook) disagreements() []FieldDisagreement {
+	var disagreements []FieldDisagreement
+
+	for _, field := range []struct {
+		name  string
+		value func(chapter *RelativeAudioBookChapter) string
+	}{
+		{AuthorField, chapterAuthor},
+		{TitleField, chapterTitle},
+	} {
+		chosen, votes := consensus(u.Chapters, field.value)
+		if len(votes) > 1 {
+			disagreements = append(disagreements, FieldDisagreement{Field: field.name, Chosen: chosen, Votes: votes})
+		}
+	}
+
+	return disagreements
+}
@@ -0,0 +1,113 @@
+package scanner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_consensus(t *testing.T) {
+	type args struct {
+		chapters []RelativeAudioBookChapter
+	}
+	tests := []struct {
+		name      string
+		args      args
+		want      string
+		wantVotes map[string]int
+	}{
+		{
+			"Unanimous",
+			args{[]RelativeAudioBookChapter{
+				{bookAuthor: "Frank Herbert", filePath: "dune/01.mp3"},
+				{bookAuthor: "Frank Herbert", filePath: "dune/02.mp3"},
+			}},
+			"Frank Herbert",
+			map[string]int{"Frank Herbert": 2},
+		},
+		{
+			"Majority",
+			args{[]RelativeAudioBookChapter{
+				{bookAuthor: "Scott Brick", filePath: "dune/01.mp3"},
+				{bookAuthor: "Frank Herbert", filePath: "dune/02.mp3"},
+				{bookAuthor: "Frank Herbert", filePath: "dune/03.mp3"},
+			}},
+			"Frank Herbert",
+			map[string]int{"Frank Herbert": 2, "Scott Brick": 1},
+		},
+		{
+			"Tie Goes To First Path",
+			args{[]RelativeAudioBookChapter{
+				{bookAuthor: "Scott Brick", filePath: "dune/02.mp3"},
+				{bookAuthor: "Frank Herbert", filePath: "dune/01.mp3"},
+			}},
+			"Frank Herbert",
+			map[string]int{"Frank Herbert": 1, "Scott Brick": 1},
+		},
+		{
+			"Missing Tags Don't Win",
+			args{[]RelativeAudioBookChapter{
+				{filePath: "dune/01.mp3"},
+				{filePath: "dune/02.mp3"},
+				{bookAuthor: "Frank Herbert", filePath: "dune/03.mp3"},
+			}},
+			"Frank Herbert",
+			map[string]int{"Frank Herbert": 1, "": 2},
+		},
+		{
+			"Untagged",
+			args{[]RelativeAudioBookChapter{{filePath: "dune/01.mp3"}}},
+			"",
+			map[string]int{"": 1},
+		},
+		{
+			"Album Artist",
+			args{[]RelativeAudioBookChapter{
+				{bookAuthor: "Scott Brick", albumArtist: "Frank Herbert", filePath: "dune/01.mp3"},
+				{bookAuthor: "Scott Brick", filePath: "dune/02.mp3"},
+				{bookAuthor: "Simon Vance", albumArtist: "Frank Herbert", filePath: "dune/03.mp3"},
+			}},
+			"Frank Herbert",
+			map[string]int{"Frank Herbert": 2, "Scott Brick": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotVotes := consensus(tt.args.chapters, chapterAuthor)
+			if got != tt.want {
+				t.Errorf("consensus() = %v, want %v", got, tt.want)
+			}
+
+			if !reflect.DeepEqual(gotVotes, tt.wantVotes) {
+				t.Errorf("consensus() votes = %v, want %v", gotVotes, tt.wantVotes)
+			}
+		})
+	}
+}
+
+func TestUnsortedBook_disagreements(t *testing.T) {
+	// The mistagged chapter is read first, but is outvoted
+	book := UnsortedBook{
+		Key: BookKey{Directory: "dune"},
+		Chapters: []RelativeAudioBookChapter{
+			{bookTitle: "Dune Messiah", bookAuthor: "Frank Herbert", discNum: 1, trackNum: 3, filePath: "dune/03.mp3"},
+			{bookTitle: "Dune", bookAuthor: "Frank Herbert", discNum: 1, trackNum: 1, filePath: "dune/01.mp3"},
+			{bookTitle: "Dune", bookAuthor: "Frank Herbert", discNum: 1, trackNum: 2, filePath: "dune/02.mp3"},
+		},
+	}
+
+	want := []FieldDisagreement{
+		{Field: TitleField, Chosen: "Dune", Votes: map[string]int{"Dune": 2, "Dune Messiah": 1}},
+	}
+	if got := book.disagreements(); !reflect.DeepEqual(got, want) {
+		t.Errorf("disagreements() = %v, want %v", got, want)
+	}
+
+	audioBook, errors := book.IntoAudioBook(SortByDiscNumber[RelativeAudioBookChapter])
+	if errors != nil {
+		t.Fatalf("IntoAudioBook() returned errors: %v", errors)
+	}
+
+	if audioBook.Title != "Dune" || audioBook.Author != "Frank Herbert" {
+		t.Errorf("IntoAudioBook() = %q by %q, want %q by %q", audioBook.Title, audioBook.Author, "Dune", "Frank Herbert")
+	}
+}
@@ -1,7 +1,11 @@
 package scanner
 
 import (
-	"github.com/themooer1/gort/math"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
 )
 
 type DiscNumber = int
@@ -20,67 +24,181 @@ type HasDiscAndTrackNumber interface {
 	HasTrackNumber
 }
 
+// HasFileSize is implemented by chapters that know the size of their file, which
+// PreferLargerDuplicate goes by
+type HasFileSize interface {
+	FileSize() int64
+}
+
+var ErrDuplicateTrack = errors.New("duplicate disc and track number")
+
+// DuplicateTrackError reports chapters with the same disc and track number
+type DuplicateTrackError struct {
+	DiscNum  DiscNumber  `json:"discNum"`
+	TrackNum TrackNumber `json:"trackNum"`
+	// The paths of the chapters' files in order, if they have them, see HasFilePath
+	Paths []string `json:"paths"`
+}
+
+func (e *DuplicateTrackError) Error() string {
+	return fmt.Sprintf("disc %d track %d is in %s", e.DiscNum, e.TrackNum, strings.Join(e.Paths, " and "))
+}
+
+func (e *DuplicateTrackError) Unwrap() error {
+	return ErrDuplicateTrack
+}
+
+// DuplicateTracks says what DiscNumberSorter does with chapters with the same disc and track number
+type DuplicateTracks int
+
+const (
+	// KeepDuplicateTracks keeps every duplicate, in order of file name.  It's the default.
+	KeepDuplicateTracks DuplicateTracks = iota
+	// FailOnDuplicateTracks fails to sort books with duplicate tracks, so Compose falls back to
+	// the next sorter
+	FailOnDuplicateTracks
+	// PreferLargerDuplicate keeps the largest duplicate, which usually has the highest bitrate,
+	// and drops the rest.  Duplicates of the same size go by file name.  See HasFileSize.
+	PreferLargerDuplicate
+)
+
+// The position of a chapter in its book
+type discAndTrack struct {
+	discNum  DiscNumber
+	trackNum TrackNumber
+}
+
+// numberLess orders missing disc and track numbers, which are zero, before the rest, which are
+// in numeric order however large or negative they are
+func numberLess(a int, b int) bool {
+	if (a == 0) != (b == 0) {
+		return a == 0
+	}
+
+	return a < b
+}
+
+func (p discAndTrack) less(q discAndTrack) bool {
+	if p.discNum != q.discNum {
+		return numberLess(p.discNum, q.discNum)
+	}
+
+	return numberLess(p.trackNum, q.trackNum)
+}
+
+// DiscNumberSorter orders chapters by disc, then by track number.  Only the numbers chapters
+// have are sorted, so it takes O(n log n) time whatever they are.
 type DiscNumberSorter[T any, TPtr interface {
 	*T
 	HasDiscAndTrackNumber
 }] struct {
-	chaptersByDiscByTrack map[DiscNumber]map[TrackNumber]T
-	chapters              []T
-	minDiscNumber         DiscNumber
-	minTrackNumberByDisc  map[DiscNumber]TrackNumber
-	maxDiscNumber         DiscNumber
-	maxTrackNumberByDisc  map[DiscNumber]TrackNumber
+	// What to do with chapters with the same disc and track number
+	Duplicates DuplicateTracks
+
+	chaptersByPosition map[discAndTrack][]T
+	// The positions chapters have, in order
+	positions  []discAndTrack
+	duplicates []*DuplicateTrackError
 }
 
 func (s *DiscNumberSorter[T, TPtr]) Initalize(chapters []T) {
-	s.chapters = chapters
-	// Initial guess 10 tracks to a disc
-	s.chaptersByDiscByTrack = make(map[DiscNumber]map[TrackNumber]T, len(s.chapters)/10)
-	s.minTrackNumberByDisc = make(map[DiscNumber]TrackNumber)
-	s.maxTrackNumberByDisc = make(map[DiscNumber]TrackNumber)
-
-	for _, chapter := range s.chapters {
-		discNum := TPtr(&chapter).DiscNum()
-		trackNum := TPtr(&chapter).TrackNum()
-
-		// Index chapter by disc and track number (creating maps if necessary)
-		if _, ok := s.chaptersByDiscByTrack[discNum]; !ok {
-			s.chaptersByDiscByTrack[discNum] = make(map[TrackNumber]T)
+	s.chaptersByPosition = make(map[discAndTrack][]T, len(chapters))
+	s.positions = make([]discAndTrack, 0, len(chapters))
+
+	for _, chapter := range chapters {
+		position := discAndTrack{discNum: TPtr(&chapter).DiscNum(), trackNum: TPtr(&chapter).TrackNum()}
+
+		if _, ok := s.chaptersByPosition[position]; !ok {
+			s.positions = append(s.positions, position)
 		}
-		s.chaptersByDiscByTrack[discNum][trackNum] = chapter
+		s.chaptersByPosition[position] = append(s.chaptersByPosition[position], chapter)
+	}
+
+	sort.Slice(s.positions, func(i, j int) bool {
+		return s.positions[i].less(s.positions[j])
+	})
+
+	s.duplicates = s.resolveDuplicates()
+}
+
+// resolveDuplicates puts chapters with the same disc and track number in order of file name,
+// keeping the largest if the sorter prefers it, and returns each set of them in order
+func (s *DiscNumberSorter[T, TPtr]) resolveDuplicates() []*DuplicateTrackError {
+	var duplicates []*DuplicateTrackError
+
+	for _, position := range s.positions {
+		chapters := s.chaptersByPosition[position]
+		if len(chapters) < 2 {
+			continue
+		}
+
+		sortByFileName[T, TPtr](chapters)
 
-		// Update min/max disc and track numbers
-		s.minDiscNumber = math.MinInt(discNum, s.minDiscNumber)
-		s.maxDiscNumber = math.MaxInt(discNum, s.maxDiscNumber)
-		s.minTrackNumberByDisc[discNum] = math.MinInt(trackNum, s.minTrackNumberByDisc[discNum])
-		s.maxTrackNumberByDisc[discNum] = math.MaxInt(trackNum, s.maxTrackNumberByDisc[discNum])
+		duplicate := &DuplicateTrackError{DiscNum: position.discNum, TrackNum: position.trackNum}
+		for i := range chapters {
+			if file, ok := any(TPtr(&chapters[i])).(HasFilePath); ok {
+				duplicate.Paths = append(duplicate.Paths, file.FilePath())
+			}
+		}
+		duplicates = append(duplicates, duplicate)
+
+		if s.Duplicates == PreferLargerDuplicate {
+			s.chaptersByPosition[position] = []T{largestFile[T, TPtr](chapters)}
+		}
+	}
+
+	return duplicates
+}
+
+// sortByFileName sorts chapters by the name of their file, then by their path, if they have one
+func sortByFileName[T any, TPtr interface{ *T }](chapters []T) {
+	sort.SliceStable(chapters, func(i, j int) bool {
+		a, aOk := any(TPtr(&chapters[i])).(HasFilePath)
+		b, bOk := any(TPtr(&chapters[j])).(HasFilePath)
+		if !aOk || !bOk {
+			return false
+		}
+
+		if path.Base(a.FilePath()) != path.Base(b.FilePath()) {
+			return path.Base(a.FilePath()) < path.Base(b.FilePath())
+		}
+
+		return a.FilePath() < b.FilePath()
+	})
+}
+
+// largestFile returns the first of the chapters with the largest file, see HasFileSize
+func largestFile[T any, TPtr interface{ *T }](chapters []T) T {
+	largest, largestSize := 0, int64(-1)
+	for i := range chapters {
+		if file, ok := any(TPtr(&chapters[i])).(HasFileSize); ok && file.FileSize() > largestSize {
+			largest, largestSize = i, file.FileSize()
+		}
 	}
+
+	return chapters[largest]
 }
 
 func (s *DiscNumberSorter[T, TPtr]) sortedUnsafe() []T {
 	sortedChapters := []T{}
 
-	for discNum := s.minDiscNumber; discNum <= s.maxDiscNumber; discNum++ {
-		if minTrackNumber, ok := s.minTrackNumberByDisc[discNum]; ok {
-			if maxTrackNumber, ok := s.maxTrackNumberByDisc[discNum]; ok {
-				for trackNum := minTrackNumber; trackNum <= maxTrackNumber; trackNum++ {
-					if chapter, ok := s.chaptersByDiscByTrack[discNum][trackNum]; ok {
-						sortedChapters = append(sortedChapters, chapter)
-					}
-				}
-			}
-		}
+	for _, position := range s.positions {
+		sortedChapters = append(sortedChapters, s.chaptersByPosition[position]...)
 	}
 
 	return sortedChapters
 }
 
 func (s *DiscNumberSorter[T, TPtr]) Sorted() ([]T, []error) {
-	// if len(s.chaptersByDiscByTrack) < len(s.chapters) {
-	// 	return nil, []error{ErrCannotSort}
-	// } else {
-	// 	return s.sortedUnsafe(), nil
-	// }
+	if len(s.duplicates) > 0 && s.Duplicates == FailOnDuplicateTracks {
+		errors := make([]error, len(s.duplicates))
+		for i, duplicate := range s.duplicates {
+			errors[i] = duplicate
+		}
+
+		return nil, errors
+	}
+
 	return s.sortedUnsafe(), nil
 }
 
@@ -94,3 +212,25 @@ func SortByDiscNumber[T any, TPtr interface {
 	return s.Sorted()
 
 }
+
+// SortByDiscNumberWith returns a sorter like SortByDiscNumber which deals with chapters
+// with the same disc and track number as duplicates says
+func SortByDiscNumberWith[T any, TPtr interface {
+	*T
+	HasDiscAndTrackNumber
+}](duplicates DuplicateTracks) Sorter[T] {
+	return func(items []T) ([]T, []error) {
+		s := DiscNumberSorter[T, TPtr]{Duplicates: duplicates}
+
+		s.Initalize(items)
+		return s.Sorted()
+	}
+}
+
+// duplicateTracks returns the chapters with the same disc and track number as others, by disc and track number
+func duplicateTracks(chapters []RelativeAudioBookChapter) []*DuplicateTrackError {
+	var s DiscNumberSorter[RelativeAudioBookChapter, *RelativeAudioBookChapter]
+
+	s.Initalize(chapters)
+	return s.duplicates
+}
@@ -1,6 +1,9 @@
 package scanner
 
 import (
+	"errors"
+	"math"
+	"reflect"
 	"testing"
 )
 
@@ -288,6 +291,82 @@ func TestSortByDiscNum(t *testing.T) {
 				},
 			},
 		},
+		{
+			"Sort with Year as Track",
+			args{
+				items: []MockChapterWithDiscNumber{
+					{
+						ord:      2,
+						discNum:  1,
+						trackNum: 2019,
+					},
+					{
+						ord:      0,
+						discNum:  1,
+						trackNum: 1,
+					},
+					{
+						ord:      1,
+						discNum:  1,
+						trackNum: 2,
+					},
+				},
+			},
+		},
+		{
+			"Sort with Extreme Numbers",
+			args{
+				items: []MockChapterWithDiscNumber{
+					{
+						ord:      2,
+						discNum:  math.MaxInt,
+						trackNum: math.MinInt,
+					},
+					{
+						ord:      3,
+						discNum:  math.MaxInt,
+						trackNum: math.MaxInt,
+					},
+					{
+						ord:      0,
+						discNum:  math.MinInt,
+						trackNum: 1,
+					},
+					{
+						ord:      1,
+						discNum:  1,
+						trackNum: 1,
+					},
+				},
+			},
+		},
+		{
+			"Sort with Missing Before Negative",
+			args{
+				items: []MockChapterWithDiscNumber{
+					{
+						ord:      1,
+						discNum:  -1,
+						trackNum: 1,
+					},
+					{
+						ord:      3,
+						discNum:  1,
+						trackNum: -1,
+					},
+					{
+						ord:      0,
+						discNum:  0,
+						trackNum: 5,
+					},
+					{
+						ord:      2,
+						discNum:  1,
+						trackNum: 0,
+					},
+				},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -307,3 +386,89 @@ func TestSortByDiscNum(t *testing.T) {
 		})
 	}
 }
+
+func TestSortByDiscNumberWith(t *testing.T) {
+	chapters := []RelativeAudioBookChapter{
+		{trackNum: 2, filePath: "book/02.mp3", fileSize: 100},
+		{trackNum: 1, filePath: "book/b/01.mp3", fileSize: 200},
+		{trackNum: 1, filePath: "book/a/01.mp3", fileSize: 100},
+		{trackNum: 1, filePath: "book/01 (copy).mp3", fileSize: 200},
+	}
+
+	type args struct {
+		duplicates DuplicateTracks
+	}
+	tests := []struct {
+		name string
+		args args
+		// Paths of the sorted chapters
+		want       []string
+		wantErrors []error
+	}{
+		{
+			"Fail",
+			args{FailOnDuplicateTracks},
+			nil,
+			[]error{&DuplicateTrackError{TrackNum: 1, Paths: []string{"book/01 (copy).mp3", "book/a/01.mp3", "book/b/01.mp3"}}},
+		},
+		{
+			"Keep",
+			args{KeepDuplicateTracks},
+			[]string{"book/01 (copy).mp3", "book/a/01.mp3", "book/b/01.mp3", "book/02.mp3"},
+			nil,
+		},
+		{
+			"Prefer Larger",
+			args{PreferLargerDuplicate},
+			[]string{"book/01 (copy).mp3", "book/02.mp3"},
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sorted, errs := SortByDiscNumberWith[RelativeAudioBookChapter](tt.args.duplicates)(chapters)
+			if !reflect.DeepEqual(errs, tt.wantErrors) {
+				t.Errorf("SortByDiscNumberWith() errors = %v, want %v", errs, tt.wantErrors)
+			}
+
+			var got []string
+			for _, chapter := range sorted {
+				got = append(got, chapter.filePath)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SortByDiscNumberWith() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortByDiscNumber_Duplicates(t *testing.T) {
+	chapters := []RelativeAudioBookChapter{
+		{discNum: 1, trackNum: 1, filePath: "book/cd1/01.mp3"},
+		{discNum: 1, trackNum: 1, filePath: "book/cd2/01.mp3"},
+		{discNum: 1, trackNum: 2, filePath: "book/cd1/02.mp3"},
+	}
+
+	// Duplicates are kept by default
+	sorted, errs := SortByDiscNumber(chapters)
+	if len(errs) != 0 || !reflect.DeepEqual(sorted, chapters) {
+		t.Errorf("SortByDiscNumber() = %v, %v, want every chapter in order of file name", sorted, errs)
+	}
+
+	failOnDuplicates := SortByDiscNumberWith[RelativeAudioBookChapter](FailOnDuplicateTracks)
+	_, errs = Compose(failOnDuplicates)(chapters)
+	if len(errs) != 1 || !errors.Is(errs[0], ErrDuplicateTrack) {
+		t.Fatalf("SortByDiscNumberWith() errors = %v, want a duplicate track", errs)
+	}
+
+	if want := "disc 1 track 1 is in book/cd1/01.mp3 and book/cd2/01.mp3"; errs[0].Error() != want {
+		t.Errorf("Error() = %q, want %q", errs[0].Error(), want)
+	}
+
+	// Compose falls back when the disc numbers can't order the book
+	sorted, errs = Compose(failOnDuplicates, SortByDiscNumber[RelativeAudioBookChapter])(chapters)
+	if len(errs) != 0 || len(sorted) != len(chapters) {
+		t.Errorf("Compose() = %v, %v, want every chapter", sorted, errs)
+	}
+}
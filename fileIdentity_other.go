@@ -0,0 +1,10 @@
+//go:build !unix
+
+package scanner
+
+import "io/fs"
+
+// fileInode returns zeroes, since inodes aren't available on this platform
+func fileInode(info fs.FileInfo) (device uint64, inode uint64) {
+	return 0, 0
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package scanner
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileInode returns the device and inode numbers of the file described by info,
+// or zeroes if its filesystem doesn't have them
+func fileInode(info fs.FileInfo) (device uint64, inode uint64) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Dev), uint64(stat.Ino)
+	}
+
+	return 0, 0
+}
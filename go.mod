@@ -3,10 +3,12 @@ module github.com/themooer1/audiobook-scanner
 go 1.19
 
 require (
-	github.com/dhowden/tag v0.0.0-20220618230019-adf36e896086 // indirect
-	github.com/edsrzf/mmap-go v1.1.0 // indirect
-	github.com/go-test/deep v1.1.0 // indirect
-	github.com/themooer1/audiobook-library v0.1.0 // indirect
-	github.com/themooer1/gort v0.1.0 // indirect
-	golang.org/x/sys v0.0.0-20211216021012-1d35b9e2eb4e // indirect
+	github.com/dhowden/tag v0.0.0-20220618230019-adf36e896086
+	github.com/edsrzf/mmap-go v1.1.0
+	github.com/fsnotify/fsnotify v1.6.0
+	github.com/go-test/deep v1.1.0
+	github.com/themooer1/audiobook-library v0.1.0
+	golang.org/x/text v0.14.0
 )
+
+require golang.org/x/sys v0.5.0 // indirect
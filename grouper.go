@@ -0,0 +1,178 @@
+package scanner
+
+import (
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dhowden/tag"
+)
+
+// Grouper returns the key of the book a chapter belongs to, or false if it can't tell,
+// so ComposeGroupers can fall back to another grouper.  Groupers may fill in tags the
+// chapter is missing, like the disc numbers DirectoryGrouper infers from disc folders.
+type Grouper func(chapter *RelativeAudioBookChapter) (BookKey, bool)
+
+// GroupByAlbum groups chapters by their album tag alone, so books with the same title are
+// merged whoever wrote them.  It groups every chapter, so libraries fall back to it for
+// chapters their grouper can't group.
+func GroupByAlbum(chapter *RelativeAudioBookChapter) (BookKey, bool) {
+	return BookKey{Title: chapter.bookTitle}, true
+}
+
+// chapterArtist returns the album artist tag of the chapter, or its artist tag if it doesn't have one
+func chapterArtist(chapter *RelativeAudioBookChapter) string {
+	if chapter.albumArtist != "" {
+		return chapter.albumArtist
+	}
+
+	return chapter.bookAuthor
+}
+
+// chapterAuthor returns the names of the chapter's authors joined with AuthorSeparator, or
+// its artist if they haven't been split, so tags naming the same authors differently agree
+func chapterAuthor(chapter *RelativeAudioBookChapter) string {
+	if chapter.authors != "" {
+		return chapter.authors
+	}
+
+	return chapterArtist(chapter)
+}
+
+// GroupByTags groups chapters by their album and their authors, from the album artist tag
+// or the artist tag in files without an album artist, see SplitAuthors.  It can't group
+// chapters without an album.  It's the default.
+func GroupByTags(chapter *RelativeAudioBookChapter) (BookKey, bool) {
+	if chapter.bookTitle == "" {
+		return BookKey{}, false
+	}
+
+	return BookKey{Title: chapter.bookTitle, Author: chapterAuthor(chapter)}, true
+}
+
+// DefaultDiscFolderPattern matches the names of folders holding one disc of a book, like
+// "CD1", "Disc 2" or "disk_03", capturing the disc number
+var DefaultDiscFolderPattern = regexp.MustCompile(`(?i)^(?:cd|dis[ck])[ ._-]*(\d+)$`)
+
+// GroupByDirectory groups chapters by the directory they're in, whatever their tags say,
+// merging disc folders matched by DefaultDiscFolderPattern into their parent.  See DirectoryGrouper.
+func GroupByDirectory(chapter *RelativeAudioBookChapter) (BookKey, bool) {
+	return groupByDirectory(chapter, DefaultDiscFolderPattern)
+}
+
+// DirectoryGrouper returns a grouper like GroupByDirectory, which merges folders whose name
+// matches discFolders into their parent, or keeps them apart if it's nil.  Chapters in disc
+// folders without a disc number tag get the number captured by the pattern's first group.
+// Books are named by the tags most of their chapters have, or by their directory if they have none.
+func DirectoryGrouper(discFolders *regexp.Regexp) Grouper {
+	return func(chapter *RelativeAudioBookChapter) (BookKey, bool) {
+		return groupByDirectory(chapter, discFolders)
+	}
+}
+
+func groupByDirectory(chapter *RelativeAudioBookChapter, discFolders *regexp.Regexp) (BookKey, bool) {
+	dir := path.Dir(chapter.fsPath())
+
+	if discFolders != nil && dir != "." {
+		if match := discFolders.FindStringSubmatch(path.Base(dir)); match != nil {
+			dir = path.Dir(dir)
+
+			if len(match) > 1 && chapter.discNum == 0 {
+				if discNum, err := strconv.Atoi(match[1]); err == nil {
+					chapter.discNum = discNum
+				}
+			}
+		}
+	}
+
+	return BookKey{Directory: chapterURL(chapter.root, dir)}, true
+}
+
+// GroupByReleaseID groups chapters by their MusicBrainz release id tag, and can't group
+// chapters without one.  Books are named by the tags most of their chapters have.
+func GroupByReleaseID(chapter *RelativeAudioBookChapter) (BookKey, bool) {
+	if chapter.releaseID == "" {
+		return BookKey{}, false
+	}
+
+	return BookKey{ReleaseID: chapter.releaseID}, true
+}
+
+// GroupByDirectoryAndTags groups chapters in the same directory with the same tags, see
+// GroupByTags.  Chapters without an album are grouped by their directory alone, so they
+// aren't merged with untagged chapters from elsewhere.
+var GroupByDirectoryAndTags = ComposeGroupers(CombineGroupers(GroupByDirectory, GroupByTags), GroupByDirectory)
+
+// ComposeGroupers groups each chapter with the first of the groupers that can
+func ComposeGroupers(groupers ...Grouper) Grouper {
+	return func(chapter *RelativeAudioBookChapter) (BookKey, bool) {
+		for _, group := range groupers {
+			if key, ok := group(chapter); ok {
+				return key, true
+			}
+		}
+
+		return BookKey{}, false
+	}
+}
+
+// CombineGroupers only groups chapters together if every one of the groupers does, and can't
+// group chapters that any of them can't
+func CombineGroupers(groupers ...Grouper) Grouper {
+	return func(chapter *RelativeAudioBookChapter) (BookKey, bool) {
+		var combined BookKey
+
+		for _, group := range groupers {
+			key, ok := group(chapter)
+			if !ok {
+				return BookKey{}, false
+			}
+
+			for _, field := range []struct{ combined, key *string }{
+				{&combined.Title, &key.Title},
+				{&combined.Author, &key.Author},
+				{&combined.Directory, &key.Directory},
+				{&combined.ReleaseID, &key.ReleaseID},
+			} {
+				if *field.key != "" {
+					*field.combined = *field.key
+				}
+			}
+		}
+
+		return combined, true
+	}
+}
+
+// The names the MusicBrainz release id is stored under by each tag format.  Vorbis
+// comments are lower case, MP4 uses iTunes' custom atoms and ID3v2 uses a TXXX frame.
+const (
+	vorbisReleaseIDName = "musicbrainz_albumid"
+	releaseIDName       = "MusicBrainz Album Id"
+)
+
+// musicBrainzReleaseID returns the MusicBrainz release id in the raw tags, or "" if there isn't one
+func musicBrainzReleaseID(raw map[string]interface{}) string {
+	if id, ok := raw[vorbisReleaseIDName].(string); ok {
+		return id
+	}
+
+	if id, ok := raw[releaseIDName].(string); ok {
+		return id
+	}
+
+	for name, value := range raw {
+		// ID3v2.2 names the frame TXX, and frames after the first get a suffix
+		frame := strings.SplitN(name, "_", 2)[0]
+		if frame != "TXXX" && frame != "TXX" {
+			continue
+		}
+
+		if comm, ok := value.(*tag.Comm); ok && comm.Description == releaseIDName {
+			return comm.Text
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,263 @@
+package scanner
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+	"testing/fstest"
+
+	"github.com/dhowden/tag"
+	"github.com/go-test/deep"
+	library "github.com/themooer1/audiobook-library"
+)
+
+func TestGroupers(t *testing.T) {
+	tagged := RelativeAudioBookChapter{bookTitle: "Dune", bookAuthor: "Scott Brick", albumArtist: "Frank Herbert", releaseID: "1234", root: "/mnt/disk1", filePath: "dune/disc1/01.mp3"}
+	untagged := RelativeAudioBookChapter{filePath: "unknown/01.mp3"}
+
+	type args struct {
+		grouper Grouper
+		chapter RelativeAudioBookChapter
+	}
+	tests := []struct {
+		name   string
+		args   args
+		want   BookKey
+		wantOk bool
+	}{
+		{"Album", args{GroupByAlbum, tagged}, BookKey{Title: "Dune"}, true},
+		{"Album Untagged", args{GroupByAlbum, untagged}, BookKey{}, true},
+		{"Tags", args{GroupByTags, tagged}, BookKey{Title: "Dune", Author: "Frank Herbert"}, true},
+		{"Tags Without Album Artist", args{GroupByTags, RelativeAudioBookChapter{bookTitle: "Dune", bookAuthor: "Frank Herbert"}}, BookKey{Title: "Dune", Author: "Frank Herbert"}, true},
+		{"Tags Untagged", args{GroupByTags, untagged}, BookKey{}, false},
+		{"Directory", args{GroupByDirectory, tagged}, BookKey{Directory: "/mnt/disk1/dune"}, true},
+		{"Directory Without Root", args{GroupByDirectory, untagged}, BookKey{Directory: "unknown"}, true},
+		{"Release ID", args{GroupByReleaseID, tagged}, BookKey{ReleaseID: "1234"}, true},
+		{"Release ID Untagged", args{GroupByReleaseID, untagged}, BookKey{}, false},
+		{"Directory And Tags", args{GroupByDirectoryAndTags, tagged}, BookKey{Title: "Dune", Author: "Frank Herbert", Directory: "/mnt/disk1/dune"}, true},
+		{"Directory And Tags Untagged", args{GroupByDirectoryAndTags, untagged}, BookKey{Directory: "unknown"}, true},
+		{"Composed", args{ComposeGroupers(GroupByReleaseID, GroupByDirectory), tagged}, BookKey{ReleaseID: "1234"}, true},
+		{"Composed Fallback", args{ComposeGroupers(GroupByReleaseID, GroupByDirectory), untagged}, BookKey{Directory: "unknown"}, true},
+		{"Composed Neither", args{ComposeGroupers(GroupByReleaseID, GroupByTags), untagged}, BookKey{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.args.grouper(&tt.args.chapter)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("Grouper() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestGroupByDirectoryAndTags_Untagged(t *testing.T) {
+	// Untagged chapters share a directory with a tagged book, and another with nothing else
+	chapters := []RelativeAudioBookChapter{
+		{bookTitle: "Dune", bookAuthor: "Frank Herbert", trackNum: 1, filePath: "dune/01.mp3"},
+		{bookTitle: "Dune", bookAuthor: "Frank Herbert", trackNum: 2, filePath: "dune/02.mp3"},
+		{filePath: "dune/interview.mp3"},
+		{filePath: "misc/01.mp3"},
+		{filePath: "misc/02.mp3"},
+	}
+
+	var unsortedLibrary UnsortedBookLibrary
+	unsortedLibrary.InitializeGroupedBy(GroupByDirectoryAndTags)
+	for _, chapter := range chapters {
+		unsortedLibrary.AddChapter(chapter)
+	}
+
+	books, titles := unsortedLibrary.titledBooks()
+
+	got := make(map[string]int)
+	for _, book := range books {
+		got[titles[book.key]] = len(unsortedLibrary.books[book.key].Chapters)
+	}
+
+	want := map[string]int{"Dune": 2, "dune": 1, "misc": 2}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Library has books with chapters %v, want %v", got, want)
+	}
+}
+
+func TestDirectoryGrouper(t *testing.T) {
+	type args struct {
+		discFolders *regexp.Regexp
+		filePath    string
+		discNum     int
+	}
+	tests := []struct {
+		name          string
+		args          args
+		wantDirectory string
+		wantDiscNum   int
+	}{
+		{"Book Directory", args{DefaultDiscFolderPattern, "book/01.mp3", 0}, "book", 0},
+		{"CD Folder", args{DefaultDiscFolderPattern, "book/CD1/01.mp3", 0}, "book", 1},
+		{"Disc Folder", args{DefaultDiscFolderPattern, "book/Disc 2/01.mp3", 0}, "book", 2},
+		{"Disk Folder", args{DefaultDiscFolderPattern, "book/disk_03/01.mp3", 0}, "book", 3},
+		{"Tagged Disc", args{DefaultDiscFolderPattern, "book/CD1/01.mp3", 4}, "book", 4},
+		{"Disc Folder At Root", args{DefaultDiscFolderPattern, "CD1/01.mp3", 0}, ".", 1},
+		{"Not A Disc Folder", args{DefaultDiscFolderPattern, "book/CD Extras/01.mp3", 0}, "book/CD Extras", 0},
+		{"Custom Pattern", args{regexp.MustCompile(`^Part (\d+)$`), "book/Part 2/01.mp3", 0}, "book", 2},
+		{"No Pattern", args{nil, "book/CD1/01.mp3", 0}, "book/CD1", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chapter := RelativeAudioBookChapter{filePath: tt.args.filePath, discNum: tt.args.discNum}
+
+			key, ok := DirectoryGrouper(tt.args.discFolders)(&chapter)
+			if !ok || key.Directory != tt.wantDirectory {
+				t.Errorf("DirectoryGrouper() = %v, %v, want directory %v", key, ok, tt.wantDirectory)
+			}
+
+			if chapter.discNum != tt.wantDiscNum {
+				t.Errorf("Chapter has disc %d, want %d", chapter.discNum, tt.wantDiscNum)
+			}
+		})
+	}
+}
+
+func Test_musicBrainzReleaseID(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  map[string]interface{}
+		want string
+	}{
+		{"Vorbis", map[string]interface{}{"album": "Dune", "musicbrainz_albumid": "1234"}, "1234"},
+		{"MP4", map[string]interface{}{"MusicBrainz Album Id": "1234"}, "1234"},
+		{
+			"ID3v2",
+			map[string]interface{}{
+				"TXXX":   &tag.Comm{Description: "MusicBrainz Artist Id", Text: "5678"},
+				"TXXX_0": &tag.Comm{Description: "MusicBrainz Album Id", Text: "1234"},
+			},
+			"1234",
+		},
+		{"ID3v2.2", map[string]interface{}{"TXX": &tag.Comm{Description: "MusicBrainz Album Id", Text: "1234"}}, "1234"},
+		{"Missing", map[string]interface{}{"TALB": "Dune"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := musicBrainzReleaseID(tt.raw); got != tt.want {
+				t.Errorf("musicBrainzReleaseID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanContext_Grouper(t *testing.T) {
+	fsys := mapFSFromDir(
+		t,
+		"testdata/audiobooks",
+		"frankenstein/frankenstein_00_shelley_64kb.mp3",
+		"frankenstein/frankenstein_01_shelley_64kb.mp3",
+	)
+	// The third chapter is in another directory
+	fsys["extra/frankenstein_02_shelley_64kb.mp3"] = mapFSFromDir(t, "testdata/audiobooks", "frankenstein/frankenstein_02_shelley_64kb.mp3")["frankenstein/frankenstein_02_shelley_64kb.mp3"]
+
+	type args struct {
+		grouper Grouper
+	}
+	tests := []struct {
+		name string
+		args args
+		// Chapters in each book, by title
+		want           map[string]int
+		wantCollisions []TitleCollision
+	}{
+		{
+			"Tags",
+			args{GroupByTags},
+			map[string]int{"Frankenstein": 3},
+			nil,
+		},
+		{
+			"Directory",
+			args{GroupByDirectory},
+			map[string]int{
+				"Frankenstein (Mary W. Shelley, extra)":        1,
+				"Frankenstein (Mary W. Shelley, frankenstein)": 2,
+			},
+			[]TitleCollision{{Title: "Frankenstein", Authors: []string{"Mary W. Shelley", "Mary W. Shelley"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var report ScanReport
+			lib := library.AudioBookLibrary{}
+			lib.Initialize()
+
+			errors := ScanContext(context.Background(), fsys, &lib, SortByDiscNumber[RelativeAudioBookChapter], ScanOptions{
+				Grouper: tt.args.grouper,
+				Report:  &report,
+			})
+			if len(errors) > 0 {
+				t.Errorf("ScanContext() returned errors: %v", errors)
+			}
+
+			got := make(map[string]int)
+			for title, book := range lib.AudioBooksByName {
+				got[title] = len(book.Chapters)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				titles := make([]string, 0, len(got))
+				for title := range got {
+					titles = append(titles, title)
+				}
+				sort.Strings(titles)
+
+				t.Errorf("Library has books %v with chapters %v, want %v", titles, got, tt.want)
+			}
+
+			if !reflect.DeepEqual(report.Collisions, tt.wantCollisions) {
+				t.Errorf("Report has collisions %v, want %v", report.Collisions, tt.wantCollisions)
+			}
+		})
+	}
+}
+
+func TestScanContext_DiscFolders(t *testing.T) {
+	source := mapFSFromDir(
+		t,
+		"testdata/audiobooks",
+		"frankenstein/frankenstein_00_shelley_64kb.mp3",
+		"frankenstein/frankenstein_01_shelley_64kb.mp3",
+		"frankenstein/frankenstein_02_shelley_64kb.mp3",
+	)
+
+	fsys := fstest.MapFS{
+		"book/CD1/frankenstein_00_shelley_64kb.mp3":    source["frankenstein/frankenstein_00_shelley_64kb.mp3"],
+		"book/CD1/frankenstein_01_shelley_64kb.mp3":    source["frankenstein/frankenstein_01_shelley_64kb.mp3"],
+		"book/Disc 2/frankenstein_02_shelley_64kb.mp3": source["frankenstein/frankenstein_02_shelley_64kb.mp3"],
+	}
+
+	lib := library.AudioBookLibrary{}
+	lib.Initialize()
+
+	errors := ScanContext(context.Background(), fsys, &lib, SortByDiscNumber[RelativeAudioBookChapter], ScanOptions{Grouper: GroupByDirectory})
+	if len(errors) > 0 {
+		t.Errorf("ScanContext() returned errors: %v", errors)
+	}
+
+	want := map[string]library.AudioBook{
+		"Frankenstein": {
+			Title:       "Frankenstein",
+			Author:      "Mary W. Shelley",
+			Description: "Description not available",
+			Chapters: []library.AudioBookChapter{
+				{Title: "00 - Letters", Index: 0, Url: "book/CD1/frankenstein_00_shelley_64kb.mp3"},
+				{Title: "01 - Chapter 1", Index: 1, Url: "book/CD1/frankenstein_01_shelley_64kb.mp3"},
+				{Title: "02 - Chapter 2", Index: 2, Url: "book/Disc 2/frankenstein_02_shelley_64kb.mp3"},
+			},
+		},
+	}
+
+	if diff := deep.Equal(want, lib.AudioBooksByName); diff != nil {
+		for _, d := range diff {
+			t.Error(d)
+		}
+	}
+}
@@ -0,0 +1,690 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrRangeRequestsUnsupported = errors.New("server does not support range requests")
+
+// ErrUnknownSize is returned for files whose size the server won't tell
+var ErrUnknownSize = errors.New("server does not report the file size")
+
+// Size of the blocks fetched by each range request.  Tag parsers make lots of
+// small reads near the start of a file, so one block usually covers the whole tag.
+const httpBlockSize = 64 * 1024
+
+// Number of blocks each open file keeps in memory
+const httpCachedBlocks = 8
+
+// Tag reads over HTTP spend most of their time waiting on the network,
+// so many more of them run at once than on a local disk.
+const httpDefaultWorkers = 16
+
+var hrefPattern = regexp.MustCompile(`(?i)<a\s[^>]*href\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+
+// HTTPFS is a read only filesystem over the files served by a plain HTTP file server.
+// Files are listed from the server's directory listings, or from a manifest if one is
+// loaded, and read with HTTP Range requests so tags can be scanned without downloading
+// whole files.  Each file's size and modification time are only fetched once.
+type HTTPFS struct {
+	baseURL *url.URL
+	client  *http.Client
+
+	mutex sync.Mutex
+	// Directory entries by directory path, filled by listings or the manifest
+	dirs map[string][]fs.DirEntry
+	// True once a manifest has been loaded, so listings are never fetched
+	hasManifest bool
+	// File sizes and modification times by path, see head
+	infos map[string]*httpFileInfo
+}
+
+// Initialize points the filesystem at baseURL, the url of its root directory.
+// If client is nil, http.DefaultClient is used.
+func (h *HTTPFS) Initialize(baseURL string, client *http.Client) error {
+	parsedURL, err := url.Parse(baseURL)
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasSuffix(parsedURL.Path, "/") {
+		parsedURL.Path += "/"
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	h.baseURL = parsedURL
+	h.client = client
+	h.dirs = make(map[string][]fs.DirEntry)
+	h.infos = make(map[string]*httpFileInfo)
+
+	return nil
+}
+
+// LoadManifest lists the filesystem from a manifest instead of directory listings.
+// The manifest is a text file at manifestName holding one file path per line,
+// relative to the root of the filesystem.  Blank lines and lines starting with # are ignored.
+func (h *HTTPFS) LoadManifest(manifestName string) error {
+	resp, err := h.client.Get(h.URL(manifestName))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching manifest %s: %s", manifestName, resp.Status)
+	}
+
+	dirs := map[string]map[string]fs.DirEntry{".": {}}
+	addEntry := func(dir string, entry fs.DirEntry) {
+		if _, ok := dirs[dir]; !ok {
+			dirs[dir] = make(map[string]fs.DirEntry)
+		}
+		dirs[dir][entry.Name()] = entry
+	}
+
+	lines := bufio.NewScanner(resp.Body)
+	for lines.Scan() {
+		name := strings.Trim(strings.TrimSpace(lines.Text()), "/")
+		if name == "" || strings.HasPrefix(name, "#") {
+			continue
+		}
+
+		if !fs.ValidPath(name) {
+			return fmt.Errorf("invalid path in manifest %s: %q", manifestName, name)
+		}
+
+		addEntry(path.Dir(name), &httpDirEntry{fsys: h, name: name})
+
+		// Add every parent directory to its own parent
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			addEntry(path.Dir(dir), &httpDirEntry{fsys: h, name: dir, isDir: true})
+		}
+	}
+
+	if err := lines.Err(); err != nil {
+		return err
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.dirs = make(map[string][]fs.DirEntry, len(dirs))
+	for dir, entries := range dirs {
+		h.dirs[dir] = sortedDirEntries(entries)
+	}
+	h.hasManifest = true
+
+	return nil
+}
+
+func sortedDirEntries(entries map[string]fs.DirEntry) []fs.DirEntry {
+	sorted := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		sorted = append(sorted, entry)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name() < sorted[j].Name()
+	})
+
+	return sorted
+}
+
+func (h *HTTPFS) defaultWorkers() int {
+	return httpDefaultWorkers
+}
+
+// URL returns the absolute url of name
+func (h *HTTPFS) URL(name string) string {
+	fileURL := *h.baseURL
+	if name != "." {
+		fileURL.Path = path.Join(h.baseURL.Path, name)
+	}
+
+	return fileURL.String()
+}
+
+func (h *HTTPFS) dirURL(name string) *url.URL {
+	dirURL := *h.baseURL
+	if name != "." {
+		dirURL.Path = path.Join(h.baseURL.Path, name) + "/"
+	}
+
+	return &dirURL
+}
+
+// get requests the url with the context
+func (h *HTTPFS) get(ctx context.Context, method string, url string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	return h.client.Do(req)
+}
+
+// fetchListing reads the entries of the directory listing served for name
+func (h *HTTPFS) fetchListing(ctx context.Context, name string) ([]fs.DirEntry, error) {
+	dirURL := h.dirURL(name)
+
+	resp, err := h.get(ctx, http.MethodGet, dirURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fs.ErrNotExist
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching listing: %s", resp.Status)
+	}
+
+	listing, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]fs.DirEntry)
+	for _, match := range hrefPattern.FindAllStringSubmatch(string(listing), -1) {
+		href := match[1] + match[2]
+
+		// Skip sorting links, anchors and anything that isn't a plain link
+		if href == "" || strings.ContainsAny(href, "?#") {
+			continue
+		}
+
+		linkURL, err := dirURL.Parse(href)
+		if err != nil || linkURL.Scheme != dirURL.Scheme || linkURL.Host != dirURL.Host {
+			continue
+		}
+
+		// Only keep direct children of the directory, skipping parent and sibling links
+		childPath := strings.TrimPrefix(linkURL.Path, dirURL.Path)
+		if childPath == linkURL.Path || childPath == "" {
+			continue
+		}
+
+		isDir := strings.HasSuffix(childPath, "/")
+		childName := strings.TrimSuffix(childPath, "/")
+		if strings.Contains(childName, "/") || childName == "." || childName == ".." {
+			continue
+		}
+
+		entries[childName] = &httpDirEntry{fsys: h, name: path.Join(name, childName), isDir: isDir}
+	}
+
+	return sortedDirEntries(entries), nil
+}
+
+func (h *HTTPFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return h.readDir(context.Background(), name)
+}
+
+func (h *HTTPFS) readDir(ctx context.Context, name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	h.mutex.Lock()
+	entries, ok := h.dirs[name]
+	hasManifest := h.hasManifest
+	h.mutex.Unlock()
+
+	if ok {
+		return entries, nil
+	} else if hasManifest {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	entries, err := h.fetchListing(ctx, name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	h.mutex.Lock()
+	h.dirs[name] = entries
+	h.mutex.Unlock()
+
+	return entries, nil
+}
+
+// isDir reports whether name is a directory, fetching its parent's listing if needed
+func (h *HTTPFS) isDir(ctx context.Context, name string) bool {
+	if name == "." {
+		return true
+	}
+
+	h.mutex.Lock()
+	_, ok := h.dirs[name]
+	h.mutex.Unlock()
+
+	if ok {
+		return true
+	}
+
+	// The parent's listing says which of its children are directories
+	parentEntries, err := h.readDir(ctx, path.Dir(name))
+	if err != nil {
+		return false
+	}
+
+	for _, entry := range parentEntries {
+		if entry.Name() == path.Base(name) {
+			return entry.IsDir()
+		}
+	}
+
+	return false
+}
+
+// head returns the size and modification time of the file at name, fetching them the first time
+func (h *HTTPFS) head(ctx context.Context, name string) (*httpFileInfo, error) {
+	h.mutex.Lock()
+	info, ok := h.infos[name]
+	h.mutex.Unlock()
+
+	if ok {
+		return info, nil
+	}
+
+	resp, err := h.get(ctx, http.MethodHead, h.URL(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fs.ErrNotExist
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", name, resp.Status)
+	}
+
+	info = &httpFileInfo{name: path.Base(name), size: resp.ContentLength}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.modTime = modTime
+	}
+
+	// Servers that stream responses may leave the length out, but still report it for ranges
+	if info.size < 0 {
+		if info.size, err = h.probeSize(ctx, name); err != nil {
+			return nil, err
+		}
+	}
+
+	h.mutex.Lock()
+	h.infos[name] = info
+	h.mutex.Unlock()
+
+	return info, nil
+}
+
+// contentRangeSize matches the complete length in a Content-Range header, like "bytes 0-0/1234"
+var contentRangeSize = regexp.MustCompile(`^bytes \d+-\d+/(\d+)$`)
+
+// probeSize requests the first byte of the file at name for the complete length of the file
+func (h *HTTPFS) probeSize(ctx context.Context, name string) (int64, error) {
+	resp, err := h.get(ctx, http.MethodGet, h.URL(name), http.Header{"Range": {"bytes=0-0"}})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return 0, ErrRangeRequestsUnsupported
+	} else if resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("fetching %s: %s", name, resp.Status)
+	}
+
+	match := contentRangeSize.FindStringSubmatch(resp.Header.Get("Content-Range"))
+	if match == nil {
+		return 0, ErrUnknownSize
+	}
+
+	return strconv.ParseInt(match[1], 10, 64)
+}
+
+func (h *HTTPFS) Stat(name string) (fs.FileInfo, error) {
+	return h.stat(context.Background(), name)
+}
+
+func (h *HTTPFS) stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if h.isDir(ctx, name) {
+		return &httpFileInfo{name: path.Base(name), isDir: true}, nil
+	}
+
+	info, err := h.head(ctx, name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+
+	return info, nil
+}
+
+func (h *HTTPFS) Open(name string) (fs.File, error) {
+	return h.open(context.Background(), name)
+}
+
+func (h *HTTPFS) open(ctx context.Context, name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if h.isDir(ctx, name) {
+		entries, err := h.readDir(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+
+		return &httpDir{info: httpFileInfo{name: path.Base(name), isDir: true}, entries: entries}, nil
+	}
+
+	info, err := h.head(ctx, name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return &httpFile{ctx: ctx, fsys: h, name: name, info: *info, blocks: make(map[int64][]byte)}, nil
+}
+
+// withContext returns a view of the filesystem whose requests are made with ctx, so
+// cancelling it stops them
+func (h *HTTPFS) withContext(ctx context.Context) fs.FS {
+	return &httpContextFS{HTTPFS: h, ctx: ctx}
+}
+
+type httpContextFS struct {
+	*HTTPFS
+	ctx context.Context
+}
+
+func (c *httpContextFS) Open(name string) (fs.File, error) {
+	return c.open(c.ctx, name)
+}
+
+func (c *httpContextFS) Stat(name string) (fs.FileInfo, error) {
+	return c.stat(c.ctx, name)
+}
+
+// ReadDir returns entries that fetch their info with the context too
+func (c *httpContextFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := c.readDir(c.ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	withContext := make([]fs.DirEntry, len(entries))
+	for i, entry := range entries {
+		if httpEntry, ok := entry.(*httpDirEntry); ok {
+			entry = &httpDirEntry{fsys: httpEntry.fsys, name: httpEntry.name, isDir: httpEntry.isDir, ctx: c.ctx}
+		}
+
+		withContext[i] = entry
+	}
+
+	return withContext, nil
+}
+
+type httpFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func (i *httpFileInfo) Name() string {
+	return i.name
+}
+
+func (i *httpFileInfo) Size() int64 {
+	return i.size
+}
+
+func (i *httpFileInfo) Mode() fs.FileMode {
+	if i.isDir {
+		return fs.ModeDir | 0555
+	}
+
+	return 0444
+}
+
+func (i *httpFileInfo) ModTime() time.Time {
+	return i.modTime
+}
+
+func (i *httpFileInfo) IsDir() bool {
+	return i.isDir
+}
+
+func (i *httpFileInfo) Sys() any {
+	return nil
+}
+
+type httpDirEntry struct {
+	fsys  *HTTPFS
+	name  string
+	isDir bool
+	// The context Info requests with, or nil for none
+	ctx context.Context
+}
+
+func (e *httpDirEntry) Name() string {
+	return path.Base(e.name)
+}
+
+func (e *httpDirEntry) IsDir() bool {
+	return e.isDir
+}
+
+func (e *httpDirEntry) Type() fs.FileMode {
+	if e.isDir {
+		return fs.ModeDir
+	}
+
+	return 0
+}
+
+// Info describes directories without a request, but fetches the size of files
+func (e *httpDirEntry) Info() (fs.FileInfo, error) {
+	if e.isDir {
+		return &httpFileInfo{name: e.Name(), isDir: true}, nil
+	}
+
+	ctx := e.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return e.fsys.stat(ctx, e.name)
+}
+
+type httpDir struct {
+	info    httpFileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *httpDir) Stat() (fs.FileInfo, error) {
+	return &d.info, nil
+}
+
+func (d *httpDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+func (d *httpDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.offset:]
+	if n > 0 && len(remaining) == 0 {
+		return nil, io.EOF
+	}
+
+	if n > 0 && n < len(remaining) {
+		remaining = remaining[:n]
+	}
+
+	d.offset += len(remaining)
+
+	return remaining, nil
+}
+
+func (d *httpDir) Close() error {
+	return nil
+}
+
+// A remote file read in blocks with HTTP Range requests
+type httpFile struct {
+	// The context the file's blocks are requested with
+	ctx    context.Context
+	fsys   *HTTPFS
+	name   string
+	info   httpFileInfo
+	offset int64
+	// Recently read blocks by block index, evicted oldest first
+	blocks     map[int64][]byte
+	blockOrder []int64
+	// Set by Close, after which reads fail with fs.ErrClosed
+	closed bool
+}
+
+func (f *httpFile) Stat() (fs.FileInfo, error) {
+	return &f.info, nil
+}
+
+func (f *httpFile) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+
+	f.closed = true
+	f.blocks = nil
+	f.blockOrder = nil
+
+	return nil
+}
+
+// fetchBlock returns the block with the given index, requesting it if it isn't cached
+func (f *httpFile) fetchBlock(index int64) ([]byte, error) {
+	if block, ok := f.blocks[index]; ok {
+		return block, nil
+	}
+
+	start := index * httpBlockSize
+	end := start + httpBlockSize - 1
+	if end >= f.info.size {
+		end = f.info.size - 1
+	}
+
+	rangeHeader := "bytes=" + strconv.FormatInt(start, 10) + "-" + strconv.FormatInt(end, 10)
+
+	resp, err := f.fsys.get(f.ctx, http.MethodGet, f.fsys.URL(f.name), http.Header{"Range": {rangeHeader}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil, ErrRangeRequestsUnsupported
+	} else if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("fetching %s: %s", f.name, resp.Status)
+	}
+
+	block := make([]byte, end-start+1)
+	if _, err := io.ReadFull(resp.Body, block); err != nil {
+		return nil, err
+	}
+
+	if len(f.blockOrder) >= httpCachedBlocks {
+		delete(f.blocks, f.blockOrder[0])
+		f.blockOrder = f.blockOrder[1:]
+	}
+	f.blocks[index] = block
+	f.blockOrder = append(f.blockOrder, index)
+
+	return block, nil
+}
+
+func (f *httpFile) ReadAt(p []byte, offset int64) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	}
+
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	read := 0
+	for read < len(p) {
+		position := offset + int64(read)
+		if position >= f.info.size {
+			return read, io.EOF
+		}
+
+		block, err := f.fetchBlock(position / httpBlockSize)
+		if err != nil {
+			return read, &fs.PathError{Op: "read", Path: f.name, Err: err}
+		}
+
+		read += copy(p[read:], block[position%httpBlockSize:])
+	}
+
+	return read, nil
+}
+
+func (f *httpFile) Read(p []byte) (int, error) {
+	read, err := f.ReadAt(p, f.offset)
+	f.offset += int64(read)
+
+	if err == io.EOF && read > 0 {
+		err = nil
+	}
+
+	return read, err
+}
+
+func (f *httpFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrClosed}
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.size
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	f.offset = offset
+
+	return offset, nil
+}
@@ -0,0 +1,305 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+
+	library "github.com/themooer1/audiobook-library"
+)
+
+type countingResponseWriter struct {
+	http.ResponseWriter
+	written *int64
+}
+
+func (w countingResponseWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	atomic.AddInt64(w.written, int64(n))
+	return n, err
+}
+
+// newTestHTTPServer serves handler, counting the bytes of every response body
+func newTestHTTPServer(t *testing.T, handler http.Handler) (*httptest.Server, *int64) {
+	var written int64
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(countingResponseWriter{w, &written}, r)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &written
+}
+
+func dirSize(t *testing.T, dir string) int64 {
+	var size int64
+
+	err := fs.WalkDir(os.DirFS(dir), ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			size += info.Size()
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return size
+}
+
+func TestHTTPFS_DirectoryListing(t *testing.T) {
+	server, written := newTestHTTPServer(t, http.FileServer(http.Dir("testdata/audiobooks")))
+
+	var httpFS HTTPFS
+	if err := httpFS.Initialize(server.URL, server.Client()); err != nil {
+		t.Fatal(err)
+	}
+
+	lib := library.AudioBookLibrary{}
+	lib.Initialize()
+
+	errors := ScanFS(&httpFS, &lib, SortByDiscNumber[RelativeAudioBookChapter])
+	if len(errors) > 0 {
+		t.Errorf("ScanFS() returned errors: %v", errors)
+	}
+
+	wantChapters := map[string]int{
+		"Frankenstein":                     22,
+		"Crime and Punishment (Version 3)": 41,
+	}
+	for title, chapters := range wantChapters {
+		if got := len(lib.Get(title).Chapters); got != chapters {
+			t.Errorf("Expected %d chapters in %s, but got %d", chapters, title, got)
+		}
+	}
+
+	wantURL := server.URL + "/frankenstein/frankenstein_00_shelley_64kb.mp3"
+	if got := lib.Get("Frankenstein").Chapters[0].Url; got != wantURL {
+		t.Errorf("Chapter url = %v, want %v", got, wantURL)
+	}
+
+	if total := dirSize(t, "testdata/audiobooks"); atomic.LoadInt64(written) >= total {
+		t.Errorf("Downloaded %d bytes, expected less than the %d bytes in the library", atomic.LoadInt64(written), total)
+	}
+}
+
+func TestHTTPFS_Manifest(t *testing.T) {
+	frankenstein00, err := os.ReadFile("testdata/audiobooks/frankenstein/frankenstein_00_shelley_64kb.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := fstest.MapFS{
+		"manifest.txt": {Data: []byte("# Books\nfrankenstein/frankenstein_00_shelley_64kb.mp3\n\n")},
+		// Not in the manifest, so never scanned
+		"frankenstein/frankenstein_01_shelley_64kb.mp3": {Data: frankenstein00},
+		"frankenstein/frankenstein_00_shelley_64kb.mp3": {Data: frankenstein00},
+	}
+	server, _ := newTestHTTPServer(t, http.FileServer(http.FS(files)))
+
+	var httpFS HTTPFS
+	if err := httpFS.Initialize(server.URL+"/", server.Client()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := httpFS.LoadManifest("manifest.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	lib := library.AudioBookLibrary{}
+	lib.Initialize()
+
+	errors := ScanFS(&httpFS, &lib, SortByDiscNumber[RelativeAudioBookChapter])
+	if len(errors) > 0 {
+		t.Errorf("ScanFS() returned errors: %v", errors)
+	}
+
+	chapters := lib.Get("Frankenstein").Chapters
+	if len(chapters) != 1 {
+		t.Fatalf("Expected 1 chapter, but got %v", chapters)
+	}
+
+	wantURL := server.URL + "/frankenstein/frankenstein_00_shelley_64kb.mp3"
+	if chapters[0].Url != wantURL {
+		t.Errorf("Chapter url = %v, want %v", chapters[0].Url, wantURL)
+	}
+}
+
+func TestHTTPFS_ReadAt(t *testing.T) {
+	data := make([]byte, 3*httpBlockSize+17)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	server, _ := newTestHTTPServer(t, http.FileServer(http.FS(fstest.MapFS{"data": {Data: data}})))
+
+	var httpFS HTTPFS
+	if err := httpFS.Initialize(server.URL, server.Client()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Compare reads spanning block boundaries with fstest's checks against the original data
+	if err := fstest.TestFS(&httpFS, "data"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHTTPFS_ReadAfterClose(t *testing.T) {
+	server, _ := newTestHTTPServer(t, http.FileServer(http.FS(fstest.MapFS{"data": {Data: []byte("some data")}})))
+
+	var httpFS HTTPFS
+	if err := httpFS.Initialize(server.URL, server.Client()); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := httpFS.Open("data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 4)
+	if _, err := file.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := file.Read(buf); !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("Read() after Close() error = %v, want %v", err, fs.ErrClosed)
+	}
+
+	if _, err := file.(io.ReaderAt).ReadAt(buf, 4); !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("ReadAt() after Close() error = %v, want %v", err, fs.ErrClosed)
+	}
+}
+
+func TestHTTPFS_UnknownLength(t *testing.T) {
+	data := make([]byte, httpBlockSize+17)
+	files := http.FileServer(http.FS(fstest.MapFS{"data": {Data: data}}))
+
+	type args struct {
+		// Serves GET requests, while HEAD requests leave the length out
+		get http.HandlerFunc
+	}
+	tests := []struct {
+		name     string
+		args     args
+		wantSize int64
+		wantErr  error
+	}{
+		{"Ranged Probe", args{files.ServeHTTP}, int64(len(data)), nil},
+		{
+			"Ranges Unsupported",
+			args{func(w http.ResponseWriter, r *http.Request) { w.Write(data) }},
+			0,
+			ErrRangeRequestsUnsupported,
+		},
+		{
+			"Unknown Complete Length",
+			args{func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Range", "bytes 0-0/*")
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(data[:1])
+			}},
+			0,
+			ErrUnknownSize,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := newTestHTTPServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodHead {
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+
+				tt.args.get(w, r)
+			}))
+
+			var httpFS HTTPFS
+			if err := httpFS.Initialize(server.URL, server.Client()); err != nil {
+				t.Fatal(err)
+			}
+
+			info, err := httpFS.Stat("data")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Stat() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if err == nil && info.Size() != tt.wantSize {
+				t.Errorf("Stat() size = %d, want %d", info.Size(), tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestHTTPFS_HeadOnce(t *testing.T) {
+	var heads int32
+	files := http.FileServer(http.FS(fstest.MapFS{"data": {Data: []byte("some data")}}))
+	server, _ := newTestHTTPServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			atomic.AddInt32(&heads, 1)
+		}
+
+		files.ServeHTTP(w, r)
+	}))
+
+	var httpFS HTTPFS
+	if err := httpFS.Initialize(server.URL, server.Client()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := httpFS.Stat("data"); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := httpFS.Open("data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	if got := atomic.LoadInt32(&heads); got != 1 {
+		t.Errorf("Sent %d HEAD requests, want 1", got)
+	}
+}
+
+func TestHTTPFS_Cancel(t *testing.T) {
+	server, _ := newTestHTTPServer(t, http.FileServer(http.FS(fstest.MapFS{"data": {Data: []byte("some data")}})))
+
+	var httpFS HTTPFS
+	if err := httpFS.Initialize(server.URL, server.Client()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := withContext(ctx, &httpFS).Open("data"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Open() error = %v, want %v", err, context.Canceled)
+	}
+
+	// The filesystem itself isn't cancelled
+	if _, err := httpFS.Open("data"); err != nil {
+		t.Errorf("Open() error = %v", err)
+	}
+}
@@ -0,0 +1,322 @@
+package scanner
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Files with this name exclude paths from walks and scans of the directory they're in,
+// using the same syntax as .gitignore files
+const IgnoreFileName = ".audiobookignore"
+
+// A line of an ignore file
+type ignorePattern struct {
+	// The directory the pattern is relative to
+	base    string
+	regexp  *regexp.Regexp
+	negated bool
+	dirOnly bool
+}
+
+// parseIgnorePattern parses a line of an ignore file in the base directory.
+// It returns false for blank lines and comments, and an error for lines that aren't valid
+// patterns, like ones with a class such as [z-a].
+func parseIgnorePattern(line string, base string) (ignorePattern, bool, error) {
+	line = strings.TrimSuffix(line, "\r")
+
+	// Trailing spaces are ignored unless they're escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignorePattern{}, false, nil
+	}
+
+	pattern := ignorePattern{base: base}
+
+	if strings.HasPrefix(line, "!") {
+		pattern.negated = true
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		pattern.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+
+	if line == "" {
+		return ignorePattern{}, false, nil
+	}
+
+	// Patterns with a slash before their end are anchored to the base, others match at any depth
+	prefix := "^(?:.*/)?"
+	if strings.Contains(line, "/") {
+		prefix = "^"
+		line = strings.TrimPrefix(line, "/")
+	}
+
+	re, err := regexp.Compile(prefix + ignoreGlobToRegexp(line) + "$")
+	if err != nil {
+		return ignorePattern{}, false, err
+	}
+	pattern.regexp = re
+
+	return pattern, true, nil
+}
+
+// ignoreGlobToRegexp translates a gitignore glob into a regular expression
+func ignoreGlobToRegexp(glob string) string {
+	var re strings.Builder
+
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+
+		switch {
+		case strings.HasPrefix(glob[i:], "**") && (i == 0 || glob[i-1] == '/') && (i+2 == len(glob) || glob[i+2] == '/'):
+			if i+2 == len(glob) {
+				// Trailing "/**" matches everything inside
+				re.WriteString(".*")
+			} else {
+				// Leading "**/" and "/**/" match any number of directories
+				re.WriteString("(?:.*/)?")
+			}
+			i += 2
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[' && strings.Contains(glob[i+1:], "]"):
+			end := i + 1 + strings.Index(glob[i+1:], "]")
+			class := glob[i+1 : end]
+
+			re.WriteString("[")
+			if strings.HasPrefix(class, "!") {
+				re.WriteString("^")
+				class = class[1:]
+			}
+			re.WriteString(strings.NewReplacer(`\`, `\\`, "[", `\[`).Replace(class))
+			re.WriteString("]")
+
+			i = end
+		case c == '\\' && i+1 < len(glob):
+			i++
+			re.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			re.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+
+	return re.String()
+}
+
+// matches reports whether the pattern matches name, a path relative to the root of the walk
+func (p *ignorePattern) matches(name string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+
+	if p.base != "." {
+		if !strings.HasPrefix(name, p.base+"/") {
+			return false
+		}
+
+		name = name[len(p.base)+1:]
+	}
+
+	return p.regexp.MatchString(name)
+}
+
+// parseIgnorePatterns parses the lines of an ignore file in the base directory, skipping the
+// ones that aren't valid patterns.  The error lists them, and is nil if there are none.
+func parseIgnorePatterns(lines []string, base string) ([]ignorePattern, error) {
+	var patterns []ignorePattern
+	var invalid []string
+	var firstErr error
+
+	for _, line := range lines {
+		pattern, ok, err := parseIgnorePattern(line, base)
+		if err != nil {
+			invalid = append(invalid, line)
+			if firstErr == nil {
+				firstErr = err
+			}
+		} else if ok {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	if firstErr != nil {
+		return patterns, fmt.Errorf("invalid ignore patterns %q: %w", invalid, firstErr)
+	}
+
+	return patterns, nil
+}
+
+// ignoreMatcher decides which paths in a filesystem are skipped, because they're junk or
+// ignored by the patterns it was given and the ignore files in each directory, which are
+// read the first time they're needed
+type ignoreMatcher struct {
+	fsys         fs.FS
+	patterns     []ignorePattern
+	junkPatterns []string
+	// Why some of the patterns were invalid, until it's been reported
+	patternsErr error
+
+	mutex sync.Mutex
+	// Each directory's ignore file
+	ignoreFiles map[string]ignoreFile
+}
+
+// Initialize creates a matcher for fsys.  Patterns are relative to the root of fsys.
+// If junkPatterns is nil, DefaultJunkPatterns are used.
+func (m *ignoreMatcher) Initialize(fsys fs.FS, patterns []string, junkPatterns []string) {
+	m.fsys = fsys
+	m.patterns, m.patternsErr = parseIgnorePatterns(patterns, ".")
+	m.ignoreFiles = make(map[string]ignoreFile)
+
+	m.junkPatterns = junkPatterns
+	if m.junkPatterns == nil {
+		m.junkPatterns = DefaultJunkPatterns
+	}
+}
+
+// An ignore file once it's been read
+type ignoreFile struct {
+	patterns []ignorePattern
+	// Why the file couldn't be read, or why some of its lines were invalid, until it's been reported
+	err error
+}
+
+// loadIgnoreFile reads dir's ignore file, unless it's already been read
+func (m *ignoreMatcher) loadIgnoreFile(dir string) {
+	m.mutex.Lock()
+	_, ok := m.ignoreFiles[dir]
+	m.mutex.Unlock()
+
+	if ok {
+		return
+	}
+
+	// Read without holding the lock, so parallel walks can read several at once
+	data, err := fs.ReadFile(m.fsys, path.Join(dir, IgnoreFileName))
+
+	file := ignoreFile{}
+	if err == nil {
+		file.patterns, file.err = parseIgnorePatterns(strings.Split(string(data), "\n"), dir)
+		if file.err != nil {
+			file.err = fmt.Errorf("%s: %w", path.Join(dir, IgnoreFileName), file.err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		file.err = err
+	}
+
+	m.mutex.Lock()
+	if _, ok := m.ignoreFiles[dir]; !ok {
+		m.ignoreFiles[dir] = file
+	}
+	m.mutex.Unlock()
+}
+
+// ignoreFilePatterns returns the patterns in dir's ignore file.  Unreadable ignore files
+// are treated as empty, invalid lines are skipped, and their error is only returned the first time.
+func (m *ignoreMatcher) ignoreFilePatterns(dir string) ([]ignorePattern, error) {
+	m.loadIgnoreFile(dir)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	file := m.ignoreFiles[dir]
+	err := file.err
+	if err != nil {
+		file.err = nil
+		m.ignoreFiles[dir] = file
+	}
+
+	return file.patterns, err
+}
+
+// ignored reports whether name is ignored.  Like git, the last pattern that matches wins,
+// and patterns in deeper directories come after the ones above them.  Paths in ignored
+// directories aren't checked, so walks must skip ignored directories.
+func (m *ignoreMatcher) ignored(name string, isDir bool) (bool, error) {
+	if name == "." {
+		return false, nil
+	}
+
+	// Directories containing name, from the root down
+	var dirs []string
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		dirs = append([]string{dir}, dirs...)
+
+		if dir == "." {
+			break
+		}
+	}
+
+	ignored := false
+	match := func(patterns []ignorePattern) {
+		for i := range patterns {
+			if patterns[i].matches(name, isDir) {
+				ignored = !patterns[i].negated
+			}
+		}
+	}
+
+	match(m.patterns)
+
+	// Invalid patterns are skipped, and reported the first time
+	m.mutex.Lock()
+	firstErr := m.patternsErr
+	m.patternsErr = nil
+	m.mutex.Unlock()
+
+	for _, dir := range dirs {
+		patterns, err := m.ignoreFilePatterns(dir)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+
+		match(patterns)
+	}
+
+	return ignored, firstErr
+}
+
+// skipReason returns why name is skipped, or "" if it isn't.  Junk is checked first,
+// so ignore files aren't read from junk directories.
+func (m *ignoreMatcher) skipReason(name string, isDir bool) (SkipReason, error) {
+	if name != "." && isJunk(name, m.junkPatterns) {
+		return SkipJunk, nil
+	}
+
+	ignored, err := m.ignored(name, isDir)
+	if ignored {
+		return SkipIgnored, err
+	}
+
+	return "", err
+}
+
+// skippedPath reports whether name or any directory containing it is skipped, for
+// callers that don't reach it by walking down from the root
+func (m *ignoreMatcher) skippedPath(name string, isDir bool) (bool, error) {
+	var firstErr error
+	for ; name != "."; name, isDir = path.Dir(name), true {
+		reason, err := m.skipReason(name, isDir)
+		if reason != "" {
+			return true, err
+		}
+
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return false, firstErr
+}
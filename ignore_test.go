@@ -0,0 +1,187 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	library "github.com/themooer1/audiobook-library"
+)
+
+func Test_ignoreMatcher_ignored(t *testing.T) {
+	fsys := fstest.MapFS{
+		".audiobookignore":           {Data: []byte("# Comment\n*.part\nsamples/\n/_incoming\n!keep.part\nbonus/**/*.pdf\n\\#hash\ntrailing   \n")},
+		"publisher/.audiobookignore": {Data: []byte("extras\n!/samples/\n")},
+	}
+
+	type args struct {
+		name  string
+		isDir bool
+	}
+	tests := []struct {
+		name string
+		args args
+		want bool
+	}{
+		{"Root", args{".", true}, false},
+		{"Extension", args{"book/chapter.part", false}, true},
+		{"Extension Negated", args{"book/keep.part", false}, false},
+		{"Other Extension", args{"book/chapter.mp3", false}, false},
+		{"Directory Only", args{"book/samples", true}, true},
+		{"Directory Only File", args{"book/samples", false}, false},
+		{"Anchored", args{"_incoming", true}, true},
+		{"Anchored Below Root", args{"book/_incoming", true}, false},
+		{"Double Star", args{"bonus/a/b/map.pdf", false}, true},
+		{"Double Star No Directories", args{"bonus/map.pdf", false}, true},
+		{"Double Star Outside", args{"book/bonus/map.pdf", false}, false},
+		{"Escaped Hash", args{"#hash", false}, true},
+		{"Trailing Spaces", args{"trailing", false}, true},
+		{"Nested File", args{"publisher/book/extras", true}, true},
+		{"Nested File Elsewhere", args{"other/extras", true}, false},
+		{"Nested File Overrides Parent", args{"publisher/samples", true}, false},
+		{"Nested File Anchored", args{"publisher/book/samples", true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m ignoreMatcher
+			m.Initialize(fsys, nil, nil)
+
+			got, err := m.ignored(tt.args.name, tt.args.isDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("ignoreMatcher.ignored() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ignoreMatcher_skippedPath(t *testing.T) {
+	var m ignoreMatcher
+	m.Initialize(fstest.MapFS{}, []string{"samples/", "!*.mp3"}, nil)
+
+	tests := []struct {
+		name  string
+		isDir bool
+		want  bool
+	}{
+		{"book/chapter.mp3", false, false},
+		{"book/samples", true, true},
+		// Negations can't bring back files in ignored directories
+		{"book/samples/chapter.mp3", false, true},
+		{"book/samples/sub/chapter.mp3", false, true},
+		{"book/@eaDir/chapter.mp3", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.skippedPath(tt.name, tt.isDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("ignoreMatcher.skippedPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Fails to list the given directory
+type unreadableDirFS struct {
+	fstest.MapFS
+	dir string
+}
+
+func (u unreadableDirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if name == u.dir {
+		return nil, errors.New("should have been skipped")
+	}
+
+	return u.MapFS.ReadDir(name)
+}
+
+func TestScanContext_Ignore(t *testing.T) {
+	fsys := mapFSFromDir(
+		t,
+		"testdata/audiobooks",
+		"frankenstein/frankenstein_00_shelley_64kb.mp3",
+		"frankenstein/frankenstein_01_shelley_64kb.mp3",
+		"crimeandpunishment/crimepunishment_00_dostoyevsky_64kb.mp3",
+	)
+	fsys[".audiobookignore"] = &fstest.MapFile{Data: []byte("frankenstein_01_*\n")}
+	fsys["crimeandpunishment/samples/sample.mp3"] = fsys["crimeandpunishment/crimepunishment_00_dostoyevsky_64kb.mp3"]
+
+	var report ScanReport
+	lib := library.AudioBookLibrary{}
+	lib.Initialize()
+
+	errors := ScanContext(context.Background(), unreadableDirFS{fsys, "crimeandpunishment/samples"}, &lib, SortByDiscNumber[RelativeAudioBookChapter], ScanOptions{
+		IgnorePatterns: []string{"samples/"},
+		Report:         &report,
+	})
+	if len(errors) > 0 {
+		t.Errorf("ScanContext() returned errors: %v", errors)
+	}
+
+	wantChapters := map[string]int{
+		"Frankenstein":                     1,
+		"Crime and Punishment (Version 3)": 1,
+	}
+	for title, chapters := range wantChapters {
+		if got := len(lib.Get(title).Chapters); got != chapters {
+			t.Errorf("Expected %d chapters in %s, but got %d", chapters, title, got)
+		}
+	}
+
+	var ignored []string
+	for _, file := range report.Files {
+		if file.SkipReason == SkipIgnored {
+			ignored = append(ignored, file.Path)
+		}
+	}
+
+	wantIgnored := []string{"crimeandpunishment/samples", "frankenstein/frankenstein_01_shelley_64kb.mp3"}
+	if len(ignored) != len(wantIgnored) || ignored[0] != wantIgnored[0] || ignored[1] != wantIgnored[1] {
+		t.Errorf("Report ignored %v, want %v", ignored, wantIgnored)
+	}
+}
+
+func TestScanContext_InvalidIgnorePatterns(t *testing.T) {
+	fsys := mapFSFromDir(
+		t,
+		"testdata/audiobooks",
+		"frankenstein/frankenstein_00_shelley_64kb.mp3",
+		"frankenstein/frankenstein_01_shelley_64kb.mp3",
+	)
+	// Classes with their range backwards can't be compiled
+	fsys["frankenstein/.audiobookignore"] = &fstest.MapFile{Data: []byte("[z-a]*.mp3\nfrankenstein_01_*\n")}
+
+	lib := library.AudioBookLibrary{}
+	lib.Initialize()
+
+	errs := ScanContext(context.Background(), fsys, &lib, SortByDiscNumber[RelativeAudioBookChapter], ScanOptions{
+		IgnorePatterns: []string{"[9-0]"},
+	})
+
+	var walkErrs int
+	for _, err := range errs {
+		var scanErr *ScanError
+		if !errors.As(err, &scanErr) || scanErr.Stage != StageWalk {
+			t.Errorf("ScanContext() returned error %v, want walk errors", err)
+		}
+		walkErrs++
+	}
+
+	if walkErrs != 2 {
+		t.Errorf("ScanContext() returned %d errors, want one for each invalid pattern: %v", walkErrs, errs)
+	}
+
+	// The valid patterns still apply
+	if got := len(lib.Get("Frankenstein").Chapters); got != 1 {
+		t.Errorf("Expected 1 chapter in Frankenstein, but got %d", got)
+	}
+}
@@ -0,0 +1,45 @@
+package scanner
+
+import "io/fs"
+
+type inodeKey struct {
+	device uint64
+	inode  uint64
+}
+
+// hasInode reports whether the filesystem gave info device and inode numbers
+func hasInode(info fs.FileInfo) bool {
+	device, inode := fileInode(info)
+	return device != 0 || inode != 0
+}
+
+// inodeSet remembers the files and directories a walk has visited by their device and inode
+type inodeSet struct {
+	inodes map[inodeKey]struct{}
+}
+
+func (s *inodeSet) Initialize() {
+	s.inodes = make(map[inodeKey]struct{})
+}
+
+// add records a visit to the entry, and reports whether it's the first.  Entries without
+// inodes can't be told apart, so each visit to them counts as the first.
+func (s *inodeSet) add(d fs.DirEntry) bool {
+	info, err := d.Info()
+	if err != nil {
+		return true
+	}
+
+	device, inode := fileInode(info)
+	if device == 0 && inode == 0 {
+		return true
+	}
+
+	key := inodeKey{device, inode}
+	if _, ok := s.inodes[key]; ok {
+		return false
+	}
+
+	s.inodes[key] = struct{}{}
+	return true
+}
@@ -0,0 +1,37 @@
+package scanner
+
+import "path"
+
+// DefaultJunkPatterns match the names of files and directories that operating systems and
+// NAS devices leave in shared folders.  They're skipped by default, see WalkOptions.JunkPatterns.
+var DefaultJunkPatterns = []string{
+	// Synology thumbnails and recycle bin
+	"@eaDir",
+	"#recycle",
+	// macOS resource forks, metadata and trash
+	".AppleDouble",
+	"._*",
+	".DS_Store",
+	".Trashes",
+	".Spotlight-V100",
+	".fseventsd",
+	// Windows
+	"Thumbs.db",
+	"desktop.ini",
+	"$RECYCLE.BIN",
+}
+
+// isJunk reports whether the base name of name matches any of the patterns.
+// It only looks at the name, so junk is skipped before any I/O happens.
+func isJunk(name string, patterns []string) bool {
+	base := path.Base(name)
+
+	for _, pattern := range patterns {
+		// Malformed patterns never match
+		if matched, _ := path.Match(pattern, base); matched {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,91 @@
+package scanner
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	library "github.com/themooer1/audiobook-library"
+)
+
+func Test_isJunk(t *testing.T) {
+	type args struct {
+		name     string
+		patterns []string
+	}
+	tests := []struct {
+		name string
+		args args
+		want bool
+	}{
+		{"Synology Thumbnails", args{"book/@eaDir", DefaultJunkPatterns}, true},
+		{"Synology Recycle Bin", args{"#recycle", DefaultJunkPatterns}, true},
+		{"Resource Fork", args{"book/._chapter.mp3", DefaultJunkPatterns}, true},
+		{"AppleDouble", args{".AppleDouble", DefaultJunkPatterns}, true},
+		{"Trash", args{".Trashes", DefaultJunkPatterns}, true},
+		{"Chapter", args{"book/chapter.mp3", DefaultJunkPatterns}, false},
+		{"Hidden Chapter", args{"book/.chapter.mp3", DefaultJunkPatterns}, false},
+		{"Custom Patterns", args{"book/@eaDir", []string{"*.tmp"}}, false},
+		{"Custom Pattern Matches", args{"book/chapter.tmp", []string{"*.tmp"}}, true},
+		{"No Patterns", args{"book/._chapter.mp3", []string{}}, false},
+		{"Malformed Pattern", args{"book/[chapter.mp3", []string{"["}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isJunk(tt.args.name, tt.args.patterns); got != tt.want {
+				t.Errorf("isJunk() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanContext_Junk(t *testing.T) {
+	fsys := mapFSFromDir(t, "testdata/audiobooks", "frankenstein/frankenstein_00_shelley_64kb.mp3")
+	fsys["frankenstein/._frankenstein_00_shelley_64kb.mp3"] = &fstest.MapFile{Data: []byte("resource fork")}
+	fsys["frankenstein/@eaDir/frankenstein_00_shelley_64kb.mp3"] = &fstest.MapFile{Data: []byte("thumbnail")}
+
+	type args struct {
+		junkPatterns []string
+	}
+	tests := []struct {
+		name       string
+		args       args
+		wantErrors int
+		wantJunk   []string
+	}{
+		{"Default Patterns", args{nil}, 0, []string{"frankenstein/._frankenstein_00_shelley_64kb.mp3", "frankenstein/@eaDir"}},
+		// The resource fork can't be read, and neither can the directory
+		{"No Patterns", args{[]string{}}, 2, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var report ScanReport
+			lib := library.AudioBookLibrary{}
+			lib.Initialize()
+
+			errors := ScanContext(context.Background(), unreadableDirFS{fsys, "frankenstein/@eaDir"}, &lib, SortByDiscNumber[RelativeAudioBookChapter], ScanOptions{
+				JunkPatterns: tt.args.junkPatterns,
+				Report:       &report,
+			})
+			if len(errors) != tt.wantErrors {
+				t.Errorf("ScanContext() returned %v, want %d errors", errors, tt.wantErrors)
+			}
+
+			if got := len(lib.Get("Frankenstein").Chapters); got != 1 {
+				t.Errorf("Expected 1 chapter, but got %d", got)
+			}
+
+			var junk []string
+			for _, file := range report.Files {
+				if file.SkipReason == SkipJunk {
+					junk = append(junk, file.Path)
+				}
+			}
+
+			if !reflect.DeepEqual(junk, tt.wantJunk) {
+				t.Errorf("Report skipped junk %v, want %v", junk, tt.wantJunk)
+			}
+		})
+	}
+}
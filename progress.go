@@ -0,0 +1,120 @@
+package scanner
+
+import (
+	"sync"
+	"time"
+)
+
+// Progress is a snapshot of a running scan
+type Progress struct {
+	// Audio files found by the walk so far
+	FilesFound int
+	// Files whose tags have been read
+	FilesRead int
+	// Files that couldn't be read
+	FilesFailed int
+	// True once the walk has finished, so FilesFound is final
+	WalkDone bool
+	// Books that chapters have been grouped into, less those merged into others, see
+	// ScanOptions.MergeSimilarTitles
+	BooksGrouped int
+	// Books that have been sorted and added to the library
+	BooksSorted int
+	// Time since the scan started
+	Elapsed time.Duration
+	// Estimated time until the scan finishes, based on the rate files and books have been
+	// processed so far.  Until the walk is done it only covers the files found so far.
+	ETA time.Duration
+}
+
+// ProgressHandler is called every time a scan's progress changes.  Calls are never
+// concurrent, but they block the scan, so handlers should return quickly.
+type ProgressHandler func(progress Progress)
+
+// Collects a scan's progress from its goroutines and reports it to a ProgressHandler
+type progressTracker struct {
+	handler ProgressHandler
+	mutex   sync.Mutex
+	start   time.Time
+	// When sorting started, zero until then
+	sortStart time.Time
+	progress  Progress
+}
+
+func (p *progressTracker) Initialize(handler ProgressHandler) {
+	p.handler = handler
+	p.start = time.Now()
+}
+
+// estimate returns how long it'll take to finish processing remaining items,
+// given that done items took elapsed
+func estimate(elapsed time.Duration, done int, remaining int) time.Duration {
+	if done == 0 {
+		return 0
+	}
+
+	return elapsed / time.Duration(done) * time.Duration(remaining)
+}
+
+// update applies change to the progress and reports it
+func (p *progressTracker) update(change func(progress *Progress)) {
+	if p.handler == nil {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	change(&p.progress)
+
+	now := time.Now()
+	p.progress.Elapsed = now.Sub(p.start)
+
+	if p.sortStart.IsZero() {
+		filesDone := p.progress.FilesRead + p.progress.FilesFailed
+		p.progress.ETA = estimate(p.progress.Elapsed, filesDone, p.progress.FilesFound-filesDone)
+	} else {
+		p.progress.ETA = estimate(now.Sub(p.sortStart), p.progress.BooksSorted, p.progress.BooksGrouped-p.progress.BooksSorted)
+	}
+
+	p.handler(p.progress)
+}
+
+func (p *progressTracker) fileFound() {
+	p.update(func(progress *Progress) { progress.FilesFound++ })
+}
+
+func (p *progressTracker) fileRead() {
+	p.update(func(progress *Progress) { progress.FilesRead++ })
+}
+
+func (p *progressTracker) fileFailed() {
+	p.update(func(progress *Progress) { progress.FilesFailed++ })
+}
+
+func (p *progressTracker) walkDone() {
+	p.update(func(progress *Progress) { progress.WalkDone = true })
+}
+
+func (p *progressTracker) bookGrouped() {
+	p.update(func(progress *Progress) { progress.BooksGrouped++ })
+}
+
+// booksMerged takes the books merged into others off the books grouped, so they aren't waited on
+func (p *progressTracker) booksMerged(merged int) {
+	if merged == 0 {
+		return
+	}
+
+	p.update(func(progress *Progress) { progress.BooksGrouped -= merged })
+}
+
+func (p *progressTracker) sortingStarted() {
+	p.mutex.Lock()
+	p.sortStart = time.Now()
+	p.mutex.Unlock()
+}
+
+func (p *progressTracker) bookSorted() {
+	p.update(func(progress *Progress) { progress.BooksSorted++ })
+}
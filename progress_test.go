@@ -0,0 +1,111 @@
+package scanner
+
+import (
+	"context"
+	"os"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	library "github.com/themooer1/audiobook-library"
+)
+
+func Test_estimate(t *testing.T) {
+	type args struct {
+		elapsed   time.Duration
+		done      int
+		remaining int
+	}
+	tests := []struct {
+		name string
+		args args
+		want time.Duration
+	}{
+		{"Nothing Done", args{time.Second, 0, 10}, 0},
+		{"Nothing Remaining", args{time.Second, 10, 0}, 0},
+		{"Half Done", args{time.Second, 10, 10}, time.Second},
+		{"Quarter Done", args{time.Second, 5, 15}, 3 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := estimate(tt.args.elapsed, tt.args.done, tt.args.remaining); got != tt.want {
+				t.Errorf("estimate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanContext_Progress(t *testing.T) {
+	var updates []Progress
+	onProgress := func(progress Progress) {
+		updates = append(updates, progress)
+	}
+
+	lib := library.AudioBookLibrary{}
+	lib.Initialize()
+
+	errors := ScanContext(
+		context.Background(),
+		os.DirFS("testdata/audiobooks"),
+		&lib,
+		SortByDiscNumber[RelativeAudioBookChapter],
+		ScanOptions{OnProgress: onProgress},
+	)
+	if len(errors) > 0 {
+		t.Errorf("ScanContext() returned errors: %v", errors)
+	}
+
+	for i, update := range updates {
+		if update.FilesRead+update.FilesFailed > update.FilesFound {
+			t.Errorf("Update %d has more files read than found: %+v", i, update)
+		}
+
+		if i > 0 && update.Elapsed < updates[i-1].Elapsed {
+			t.Errorf("Update %d went back in time: %+v", i, update)
+		}
+	}
+
+	want := Progress{
+		FilesFound:   63,
+		FilesRead:    63,
+		FilesFailed:  0,
+		WalkDone:     true,
+		BooksGrouped: 2,
+		BooksSorted:  2,
+	}
+
+	final := updates[len(updates)-1]
+	final.Elapsed = 0
+	if final != want {
+		t.Errorf("Final progress = %+v, want %+v", final, want)
+	}
+}
+
+func TestScanContext_ProgressMergedBooks(t *testing.T) {
+	fsys := fstest.MapFS{
+		"frankenstein/01.mp3": id3File("Frankenstein", "Mary Shelley", 1),
+		"frankenstein/02.mp3": id3File("Frankenstein", "Mary Shelley", 2),
+		"frankenstein/03.mp3": id3File("Frankensteinn", "Mary Shelley", 3),
+	}
+
+	var final Progress
+	onProgress := func(progress Progress) {
+		final = progress
+	}
+
+	lib := library.AudioBookLibrary{}
+	lib.Initialize()
+
+	errors := ScanContext(context.Background(), fsys, &lib, SortByDiscNumber[RelativeAudioBookChapter], ScanOptions{
+		MergeSimilarTitles: DefaultTitleSimilarity,
+		OnProgress:         onProgress,
+	})
+	if len(errors) > 0 {
+		t.Errorf("ScanContext() returned errors: %v", errors)
+	}
+
+	// The merged book isn't left to sort
+	if final.BooksGrouped != 1 || final.BooksSorted != 1 || final.ETA != 0 {
+		t.Errorf("Final progress = %+v, want 1 book grouped and sorted with no time left", final)
+	}
+}
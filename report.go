@@ -0,0 +1,261 @@
+package scanner
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileOutcome says what a scan did with a file
+type FileOutcome string
+
+const (
+	FileRead    FileOutcome = "read"
+	FileSkipped FileOutcome = "skipped"
+	FileFailed  FileOutcome = "failed"
+)
+
+// SkipReason says why a scan skipped a file
+type SkipReason string
+
+const (
+	// The file doesn't have a supported audio file extension
+	SkipExtension SkipReason = "extension"
+	// The file or directory is excluded by an ignore pattern.  Files in ignored directories aren't listed.
+	SkipIgnored SkipReason = "ignored"
+	// The file or directory is left by an operating system or NAS, see DefaultJunkPatterns
+	SkipJunk SkipReason = "junk"
+	// The file or directory was already reached through another path, by following symlinks
+	SkipDuplicate SkipReason = "duplicate"
+)
+
+// FileReport is the outcome of scanning one file
+type FileReport struct {
+	Path    string      `json:"path"`
+	Outcome FileOutcome `json:"outcome"`
+	// Why the file was skipped, if it was
+	SkipReason SkipReason `json:"skipReason,omitempty"`
+	// The stage the file failed in and the error, if it failed
+	Stage ScanStage `json:"stage,omitempty"`
+	Error string    `json:"error,omitempty"`
+	// The title of the book the file ended up in, if it was read and its book was built
+	Book string `json:"book,omitempty"`
+}
+
+// BookReport is the outcome of building one book
+type BookReport struct {
+	Title  string `json:"title"`
+	Author string `json:"author"`
+	// The authors in Author, see SplitAuthors
+	Authors  []Author `json:"authors,omitempty"`
+	Chapters int      `json:"chapters"`
+	// The roots the book's chapters came from, if they were scanned from named roots, see Root
+	Roots []string `json:"roots,omitempty"`
+	// Name of the sorter that ordered the chapters, if they were sorted.  It's the name given
+	// with Named, or else the sorter's function name, like "SortByDiscNumber".
+	Sorter string `json:"sorter,omitempty"`
+	// Whether the book was added to the library
+	Added  bool     `json:"added"`
+	Errors []string `json:"errors,omitempty"`
+	// Chapters with the same disc and track number, see DuplicateTracks
+	DuplicateTracks []*DuplicateTrackError `json:"duplicateTracks,omitempty"`
+	// Fields the book's chapters had different tags for, see consensus
+	Disagreements []FieldDisagreement `json:"disagreements,omitempty"`
+}
+
+// ScanTimings is the time spent in each stage of a scan.  Walking and reading
+// overlap, so Read covers the time from the start of the scan until the last file is read.
+// Chapters are grouped as they're read, so Group overlaps Read too.
+type ScanTimings struct {
+	Walk  time.Duration `json:"walk"`
+	Read  time.Duration `json:"read"`
+	Group time.Duration `json:"group"`
+	Sort  time.Duration `json:"sort"`
+	Total time.Duration `json:"total"`
+}
+
+// ScanReport records what happened in a scan, for auditing.  Files and books are sorted by path and title.
+// It can be serialized to JSON.
+type ScanReport struct {
+	Started time.Time    `json:"started"`
+	Files   []FileReport `json:"files"`
+	Books   []BookReport `json:"books"`
+	// Books merged into others, see ScanOptions.MergeSimilarTitles
+	Merges []TitleMerge `json:"merges,omitempty"`
+	// Titles shared by several books, see TitleCollision
+	Collisions []TitleCollision `json:"collisions,omitempty"`
+	// The books added to the library by each of their authors, in order of sort name
+	Authors []AuthorBooks `json:"authors,omitempty"`
+	Timings ScanTimings   `json:"timings"`
+
+	mutex sync.Mutex
+	// The title of the book each file ended up in, by path, see FileReport.Book
+	bookTitlesByFile map[string]string
+}
+
+// The methods below are safe to call on a nil report, which records nothing
+
+func (r *ScanReport) start() {
+	if r == nil {
+		return
+	}
+
+	r.Started = time.Now()
+}
+
+func (r *ScanReport) addFile(file FileReport) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.Files = append(r.Files, file)
+}
+
+func (r *ScanReport) fileSkipped(path string, reason SkipReason) {
+	r.addFile(FileReport{Path: path, Outcome: FileSkipped, SkipReason: reason})
+}
+
+func (r *ScanReport) fileRead(path string) {
+	r.addFile(FileReport{Path: path, Outcome: FileRead})
+}
+
+func (r *ScanReport) fileFailed(err *ScanError) {
+	r.addFile(FileReport{Path: err.Path, Outcome: FileFailed, Stage: err.Stage, Error: err.Err.Error()})
+}
+
+func (r *ScanReport) addBook(book BookReport) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.Books = append(r.Books, book)
+}
+
+// bookFiles records that the files at paths ended up in the book titled title
+func (r *ScanReport) bookFiles(title string, paths []string) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.bookTitlesByFile == nil {
+		r.bookTitlesByFile = make(map[string]string)
+	}
+
+	for _, path := range paths {
+		r.bookTitlesByFile[path] = title
+	}
+}
+
+func (r *ScanReport) addMerges(merges []TitleMerge) {
+	if r == nil || len(merges) == 0 {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.Merges = append(r.Merges, merges...)
+}
+
+func (r *ScanReport) addCollisions(collisions []TitleCollision) {
+	if r == nil || len(collisions) == 0 {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.Collisions = append(r.Collisions, collisions...)
+}
+
+// recordTimings lets record update the report's timings
+func (r *ScanReport) recordTimings(record func(timings *ScanTimings)) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	record(&r.Timings)
+}
+
+// finish sorts the report and records the total time taken
+func (r *ScanReport) finish() {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// Books are only titled once they're built, long after their files are read
+	for i := range r.Files {
+		if r.Files[i].Outcome == FileRead {
+			r.Files[i].Book = r.bookTitlesByFile[r.Files[i].Path]
+		}
+	}
+
+	sort.SliceStable(r.Files, func(i, j int) bool {
+		return r.Files[i].Path < r.Files[j].Path
+	})
+	sort.SliceStable(r.Books, func(i, j int) bool {
+		return r.Books[i].Title < r.Books[j].Title
+	})
+	sort.SliceStable(r.Merges, func(i, j int) bool {
+		return r.Merges[i].Into < r.Merges[j].Into
+	})
+	sort.SliceStable(r.Collisions, func(i, j int) bool {
+		return r.Collisions[i].Title < r.Collisions[j].Title
+	})
+
+	r.Authors = indexAuthors(r.Books)
+
+	r.Timings.Total = time.Since(r.Started)
+}
+
+// indexAuthors lists the added books by each of their authors, whatever the case of their
+// name.  Each author keeps the first sort name they're given.  Books must be in order of title.
+func indexAuthors(books []BookReport) []AuthorBooks {
+	index := make(map[string]int)
+	var authors []AuthorBooks
+
+	for _, book := range books {
+		if !book.Added {
+			continue
+		}
+
+		for _, author := range book.Authors {
+			folded := strings.ToLower(author.Name)
+
+			i, ok := index[folded]
+			if !ok {
+				i = len(authors)
+				index[folded] = i
+				authors = append(authors, AuthorBooks{Author: author})
+			}
+
+			authors[i].Books = append(authors[i].Books, book.Title)
+		}
+	}
+
+	sort.Slice(authors, func(i, j int) bool {
+		if authors[i].SortName != authors[j].SortName {
+			return authors[i].SortName < authors[j].SortName
+		}
+
+		return authors[i].Name < authors[j].Name
+	})
+
+	return authors
+}
@@ -0,0 +1,144 @@
+package scanner
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"testing/fstest"
+
+	"github.com/go-test/deep"
+	library "github.com/themooer1/audiobook-library"
+)
+
+func failingSorter(chapters []RelativeAudioBookChapter) ([]RelativeAudioBookChapter, []error) {
+	return nil, []error{ErrCannotSort}
+}
+
+func TestScanContext_Report(t *testing.T) {
+	fsys := mapFSFromDir(
+		t,
+		"testdata/audiobooks",
+		"frankenstein/frankenstein_00_shelley_64kb.mp3",
+		"frankenstein/frankenstein_01_shelley_64kb.mp3",
+	)
+	fsys["frankenstein/cover.jpg"] = &fstest.MapFile{Data: []byte("not really a jpeg")}
+	fsys["frankenstein/garbage.mp3"] = &fstest.MapFile{Data: []byte("not really an mp3")}
+
+	sorter := Compose(
+		Named("failing", failingSorter),
+		Named("filename", SortByFilename[RelativeAudioBookChapter]),
+	)
+
+	var report ScanReport
+
+	lib := library.AudioBookLibrary{}
+	lib.Initialize()
+
+	ScanContext(context.Background(), fsys, &lib, sorter, ScanOptions{Report: &report})
+
+	if len(report.Files) != 4 {
+		t.Fatalf("Expected 4 files in the report, but got %v", report.Files)
+	}
+
+	wantFiles := []FileReport{
+		{Path: "frankenstein/cover.jpg", Outcome: FileSkipped, SkipReason: SkipExtension},
+		{Path: "frankenstein/frankenstein_00_shelley_64kb.mp3", Outcome: FileRead, Book: "Frankenstein"},
+		{Path: "frankenstein/frankenstein_01_shelley_64kb.mp3", Outcome: FileRead, Book: "Frankenstein"},
+		{Path: "frankenstein/garbage.mp3", Outcome: FileFailed, Stage: StageTag, Error: report.Files[3].Error},
+	}
+	if diff := deep.Equal(wantFiles, report.Files); diff != nil {
+		t.Error(diff)
+	}
+
+	wantBooks := []BookReport{
+		{
+			Title:    "Frankenstein",
+			Author:   "Mary W. Shelley",
+			Authors:  []Author{{Name: "Mary W. Shelley", SortName: "Shelley, Mary W."}},
+			Chapters: 2,
+			Sorter:   "filename",
+			Added:    true,
+		},
+	}
+	if diff := deep.Equal(wantBooks, report.Books); diff != nil {
+		t.Error(diff)
+	}
+
+	wantAuthors := []AuthorBooks{
+		{Author: Author{Name: "Mary W. Shelley", SortName: "Shelley, Mary W."}, Books: []string{"Frankenstein"}},
+	}
+	if diff := deep.Equal(wantAuthors, report.Authors); diff != nil {
+		t.Error(diff)
+	}
+
+	if report.Timings.Total <= 0 || report.Timings.Read > report.Timings.Total || report.Timings.Group <= 0 {
+		t.Errorf("Unexpected timings: %+v", report.Timings)
+	}
+
+	if _, err := json.Marshal(&report); err != nil {
+		t.Errorf("Failed to serialize report: %v", err)
+	}
+}
+
+func TestScanContext_ReportBookTitles(t *testing.T) {
+	fsys := mapFSFromDir(
+		t,
+		"testdata/audiobooks",
+		"frankenstein/frankenstein_00_shelley_64kb.mp3",
+		"frankenstein/frankenstein_01_shelley_64kb.mp3",
+	)
+	// The same book in another directory is given a disambiguated title
+	fsys["extra/frankenstein_02_shelley_64kb.mp3"] = mapFSFromDir(t, "testdata/audiobooks", "frankenstein/frankenstein_02_shelley_64kb.mp3")["frankenstein/frankenstein_02_shelley_64kb.mp3"]
+
+	var report ScanReport
+	lib := library.AudioBookLibrary{}
+	lib.Initialize()
+
+	ScanContext(context.Background(), fsys, &lib, SortByDiscNumber[RelativeAudioBookChapter], ScanOptions{Grouper: GroupByDirectory, Report: &report})
+
+	wantFiles := []FileReport{
+		{Path: "extra/frankenstein_02_shelley_64kb.mp3", Outcome: FileRead, Book: "Frankenstein (Mary W. Shelley, extra)"},
+		{Path: "frankenstein/frankenstein_00_shelley_64kb.mp3", Outcome: FileRead, Book: "Frankenstein (Mary W. Shelley, frankenstein)"},
+		{Path: "frankenstein/frankenstein_01_shelley_64kb.mp3", Outcome: FileRead, Book: "Frankenstein (Mary W. Shelley, frankenstein)"},
+	}
+	if diff := deep.Equal(wantFiles, report.Files); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestScanContext_ReportMergedBooks(t *testing.T) {
+	fsys := fstest.MapFS{
+		"frankenstein/01.mp3": id3File("Frankenstein", "Mary Shelley", 1),
+		"frankenstein/02.mp3": id3File("Frankenstein", "Mary Shelley", 2),
+		"frankenstein/03.mp3": id3File("Frankensteinn", "Mary Shelley", 3),
+	}
+
+	var report ScanReport
+
+	lib := library.AudioBookLibrary{}
+	lib.Initialize()
+
+	errors := ScanContext(context.Background(), fsys, &lib, SortByDiscNumber[RelativeAudioBookChapter], ScanOptions{
+		MergeSimilarTitles: DefaultTitleSimilarity,
+		Report:             &report,
+	})
+	if len(errors) > 0 {
+		t.Errorf("ScanContext() returned errors: %v", errors)
+	}
+
+	if len(report.Merges) != 1 {
+		t.Errorf("Report has merges %+v, want Frankensteinn merged into Frankenstein", report.Merges)
+	}
+
+	// The typo's file is in the book it was merged into
+	for _, file := range report.Files {
+		if file.Book != "Frankenstein" {
+			t.Errorf("%s is in book %q, want Frankenstein", file.Path, file.Book)
+		}
+	}
+
+	// Sorters that weren't named are reported by their function name
+	if len(report.Books) != 1 || report.Books[0].Sorter != "SortByDiscNumber" {
+		t.Errorf("Report has books %+v, want Frankenstein sorted by SortByDiscNumber", report.Books)
+	}
+}
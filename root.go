@@ -0,0 +1,66 @@
+package scanner
+
+import (
+	"context"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+
+	library "github.com/themooer1/audiobook-library"
+)
+
+// Root is one of the trees scanned by ScanRoots
+type Root struct {
+	// Identifies the root, and is joined with the paths of the root's chapters to make
+	// their urls.  For local directories it's the directory's path, and for an HTTPFS its url.
+	Path string
+	// The tree to scan.  If nil, the directory at Path is scanned.
+	FS fs.FS
+}
+
+// chapterURL joins a chapter's path, relative to its root, with the root.  Roots that are
+// urls are joined like urls, and others like paths on this system.  Paths that are already
+// urls, like an HTTPFS's, are kept as they are.
+func chapterURL(root string, filePath string) string {
+	if root == "" || isURL(filePath) {
+		return filePath
+	}
+
+	if isURL(root) {
+		rootURL, _ := url.Parse(root)
+		rootURL.Path = path.Join("/", rootURL.Path, filePath)
+		return rootURL.String()
+	}
+
+	return filepath.Join(root, filepath.FromSlash(filePath))
+}
+
+// isURL reports whether s is an absolute url.  Windows paths like C:\disk1 parse with a
+// scheme, but no host.
+func isURL(s string) bool {
+	parsed, err := url.Parse(s)
+	return err == nil && parsed.Scheme != "" && parsed.Host != ""
+}
+
+// ScanRoots scans every root like ScanContext, grouping chapters from all of them into the
+// same books, so books split across disks are merged.  Each chapter records its root and
+// its path relative to the root.
+func ScanRoots(ctx context.Context, roots []Root, library *library.AudioBookLibrary, sorter Sorter[RelativeAudioBookChapter], options ScanOptions) []error {
+	return scanFS(ctx, roots, sorter, StreamByTags, library.Add, options)
+}
+
+// resolveRoots returns a copy of roots with their filesystems opened
+func resolveRoots(roots []Root) []Root {
+	resolved := make([]Root, len(roots))
+
+	for i, root := range roots {
+		resolved[i] = root
+		if root.FS == nil {
+			resolved[i].FS = os.DirFS(root.Path)
+		}
+	}
+
+	return resolved
+}
@@ -0,0 +1,103 @@
+package scanner
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ScanStage names the step of a scan that an error happened in
+type ScanStage string
+
+const (
+	// Listing directories
+	StageWalk ScanStage = "walk"
+	// Opening an audio file
+	StageOpen ScanStage = "open"
+	// Mapping an audio file into memory
+	StageMmap ScanStage = "mmap"
+	// Buffering an audio file that can't seek
+	StageRead ScanStage = "read"
+	// Parsing an audio file's tags
+	StageTag ScanStage = "tag"
+	// Grouping chapters into books
+	StageGroup ScanStage = "group"
+	// Sorting a book's chapters
+	StageSort ScanStage = "sort"
+)
+
+// ScanError describes something that went wrong during a scan.  File errors have a Path,
+// while grouping and sorting errors have the Book they happened in.
+type ScanError struct {
+	Path  string
+	Book  string
+	Stage ScanStage
+	Err   error
+}
+
+func (e *ScanError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%s book %q: %v", e.Stage, e.Book, e.Err)
+	}
+
+	return fmt.Sprintf("%s %s: %v", e.Stage, e.Path, e.Err)
+}
+
+func (e *ScanError) Unwrap() error {
+	return e.Err
+}
+
+// ScanErrorHandler receives scan errors as they happen.  Calls are never concurrent.
+type ScanErrorHandler func(err *ScanError)
+
+// Collects the errors of a scan from its goroutines, passing each to a ScanErrorHandler
+type scanErrorCollector struct {
+	handler ScanErrorHandler
+	mutex   sync.Mutex
+	errors  []error
+}
+
+func (c *scanErrorCollector) Initialize(handler ScanErrorHandler) {
+	c.handler = handler
+}
+
+func (c *scanErrorCollector) add(err *ScanError) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.errors = append(c.errors, err)
+
+	if c.handler != nil {
+		c.handler(err)
+	}
+}
+
+// scanErrorLess orders errors by path, then book, stage and message, so scans of the
+// same tree return the same errors in the same order
+func scanErrorLess(a *ScanError, b *ScanError) bool {
+	if a.Path != b.Path {
+		return a.Path < b.Path
+	}
+
+	if a.Book != b.Book {
+		return a.Book < b.Book
+	}
+
+	if a.Stage != b.Stage {
+		return a.Stage < b.Stage
+	}
+
+	return a.Err.Error() < b.Err.Error()
+}
+
+// collected returns the errors in a deterministic order, see scanErrorLess
+func (c *scanErrorCollector) collected() []error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	sort.SliceStable(c.errors, func(i, j int) bool {
+		return scanErrorLess(c.errors[i].(*ScanError), c.errors[j].(*ScanError))
+	})
+
+	return c.errors
+}
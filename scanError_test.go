@@ -0,0 +1,84 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	library "github.com/themooer1/audiobook-library"
+)
+
+func TestScanError_Error(t *testing.T) {
+	tests := []struct {
+		name string
+		err  ScanError
+		want string
+	}{
+		{"File Error", ScanError{Path: "book/chapter.mp3", Stage: StageTag, Err: errors.New("no tags")}, "tag book/chapter.mp3: no tags"},
+		{"Book Error", ScanError{Book: "Frankenstein", Stage: StageSort, Err: ErrCannotSort}, `sort book "Frankenstein": cannot sort chapters`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.err.Error(); got != tt.want {
+				t.Errorf("ScanError.Error() = %v, want %v", got, tt.want)
+			}
+
+			if !errors.Is(&tt.err, tt.err.Err) {
+				t.Errorf("ScanError doesn't unwrap to %v", tt.err.Err)
+			}
+		})
+	}
+}
+
+func TestScanContext_Errors(t *testing.T) {
+	var zipFS ZipFS
+	zipFS.Initialize(fstest.MapFS{
+		"book/garbage.mp3": {Data: []byte("not really an mp3")},
+		"broken.zip":       {Data: []byte("not really a zip")},
+	})
+	defer zipFS.Close()
+
+	var streamed []*ScanError
+	onError := func(err *ScanError) {
+		streamed = append(streamed, err)
+	}
+
+	lib := library.AudioBookLibrary{}
+	lib.Initialize()
+
+	errs := ScanContext(context.Background(), &zipFS, &lib, SortByDiscNumber[RelativeAudioBookChapter], ScanOptions{OnError: onError})
+
+	// Returned in order of path, whatever order they were streamed in
+	want := []ScanError{
+		{Path: "book/garbage.mp3", Stage: StageTag},
+		{Path: "broken.zip", Stage: StageWalk},
+	}
+
+	if len(errs) != len(want) || len(streamed) != len(want) {
+		t.Fatalf("Expected %d errors, but got %v, streamed %v", len(want), errs, streamed)
+	}
+
+	for i, err := range errs {
+		var scanErr *ScanError
+		if !errors.As(err, &scanErr) {
+			t.Fatalf("Expected a ScanError, got %v", err)
+		}
+
+		if streamed[0] != scanErr && streamed[1] != scanErr {
+			t.Errorf("Returned error %v wasn't streamed, got %v", scanErr, streamed)
+		}
+
+		if key := (ScanError{Path: scanErr.Path, Stage: scanErr.Stage}); key != want[i] {
+			t.Errorf("Error %d = %v, want %v", i, key, want[i])
+		}
+	}
+
+	var pathErr *fs.PathError
+	for _, err := range errs {
+		if err.(*ScanError).Stage == StageWalk && !errors.As(err, &pathErr) {
+			t.Errorf("Walk error doesn't wrap the underlying fs.PathError: %v", err)
+		}
+	}
+}
@@ -1,85 +1,477 @@
 package scanner
 
 import (
-	"fmt"
+	"context"
 	"io/fs"
+	"runtime"
+	"sort"
 	"sync"
+	"time"
 
 	library "github.com/themooer1/audiobook-library"
 )
 
-func fileScanner(filesToScan <-chan string, chaptersOut chan<- RelativeAudioBookChapter, errorHandler func(path string, err error), wg *sync.WaitGroup) {
+// Reads the chapter stored at the given path
+type chapterReader func(path string) (RelativeAudioBookChapter, error)
+
+// ScanOptions configures a scan.  The zero value scans with the defaults.
+type ScanOptions struct {
+	// Number of goroutines reading tags.  If zero, DefaultWorkers picks it from the filesystem.
+	Workers int
+	// Called whenever the scan makes progress, if not nil
+	OnProgress ProgressHandler
+	// Called with each error as it happens, if not nil.  The errors are returned by the scan either way.
+	OnError ScanErrorHandler
+	// Filled with what happened to every file and book, if not nil
+	Report *ScanReport
+	// Remembers tags between scans so unchanged files aren't read again, if not nil.
+	// It must be loaded before the scan, and saved afterwards to keep what the scan read.
+	TagCache *TagCache
+	// Gitignore-style patterns, relative to the root of the scan, for paths to skip
+	// on top of the ones in IgnoreFileName files
+	IgnorePatterns []string
+	// Patterns for the names of junk files and directories to skip, matched with path.Match.
+	// If nil, DefaultJunkPatterns are used, so set an empty slice to keep everything.
+	JunkPatterns []string
+	// Whether to follow symlinks to directories, see WalkOptions.FollowSymlinks
+	FollowSymlinks bool
+	// Number of directories listed at once, see WalkOptions.Concurrency.  Listing directories in
+	// parallel helps when they're slow to list, like on network shares.
+	WalkConcurrency int
+	// Whether to scan inside .zip archives found in the roots, see ZipFS.  The archives are
+	// closed when the scan is done.
+	OpenZipArchives bool
+	// Orders and limits tag reads to suit the storage, like SpinningDiskSchedule
+	Schedule IOSchedule
+	// Groups chapters into books.  If nil, GroupByTags is used.
+	Grouper Grouper
+	// Books by the same author whose titles are at least this similar once normalized, from 0 to 1,
+	// are merged, like "The Hobbit (Unabridged)" and "The Hobbit - Disc 1".  One only merges titles
+	// that normalize to the same string, and zero doesn't merge books.  See DefaultTitleSimilarity.
+	MergeSimilarTitles float64
+	// Separators between the authors in artist tags, which books are grouped by and indexed under
+	// in the report.  If nil, DefaultAuthorSeparators are used.  See SplitAuthors.
+	AuthorSeparators []string
+}
+
+// Filesystems that know how many concurrent reads suit their storage implement this
+type workerCountHinter interface {
+	defaultWorkers() int
+}
+
+// Filesystems that make requests which can be cancelled implement this
+type contextFS interface {
+	// withContext returns a view of the filesystem whose requests are cancelled with ctx
+	withContext(ctx context.Context) fs.FS
+}
+
+// withContext returns a view of fsys that stops its requests when ctx is cancelled, if it can
+func withContext(ctx context.Context, fsys fs.FS) fs.FS {
+	if cancellable, ok := fsys.(contextFS); ok {
+		return cancellable.withContext(ctx)
+	}
+
+	return fsys
+}
+
+// DefaultWorkers returns the number of tag reading goroutines used to scan fsys
+// when ScanOptions.Workers is zero.  Local filesystems get one per CPU, while
+// remote filesystems get more to hide the latency of each request.
+func DefaultWorkers(fsys fs.FS) int {
+	if hinter, ok := fsys.(workerCountHinter); ok {
+		return hinter.defaultWorkers()
+	}
+
+	return runtime.NumCPU()
+}
+
+func fileScanner[F any](ctx context.Context, readChapter func(file F) (RelativeAudioBookChapter, error), filesToScan <-chan F, chaptersOut chan<- RelativeAudioBookChapter, errorHandler func(file F, err error), wg *sync.WaitGroup) {
+	defer wg.Done()
+
 	for file := range filesToScan {
-		chapter, err := fromFile(file)
+		if ctx.Err() != nil {
+			return
+		}
 
-		if err != nil {
+		chapter, err := readChapter(file)
+
+		// Reads stopped by the cancellation aren't failures
+		if err != nil && ctx.Err() != nil {
+			return
+		} else if err != nil {
 			errorHandler(file, err)
 		} else {
 			chaptersOut <- chapter
 		}
 	}
-
-	wg.Done()
 }
 
-func startFileScanners(scanners int, filesToScan <-chan string, chaptersOut chan<- RelativeAudioBookChapter, errorHandler func(path string, err error)) {
+func startFileScanners[F any](ctx context.Context, scanners int, readChapter func(file F) (RelativeAudioBookChapter, error), filesToScan <-chan F, chaptersOut chan<- RelativeAudioBookChapter, errorHandler func(file F, err error)) {
 	var wg sync.WaitGroup
 	wg.Add(scanners)
 
 	for i := 0; i < scanners; i++ {
-		go fileScanner(filesToScan, chaptersOut, errorHandler, &wg)
+		go fileScanner(ctx, readChapter, filesToScan, chaptersOut, errorHandler, &wg)
 	}
 
 	wg.Wait()
 	close(chaptersOut)
 }
 
-func importIntoUnsortedLibrary(library *UnsortedBookLibrary, chapters <-chan RelativeAudioBookChapter) {
+// importChapters passes each chapter read to addChapter
+func importChapters(chapters <-chan RelativeAudioBookChapter, addChapter func(chapter RelativeAudioBookChapter), progress *progressTracker, report *ScanReport) {
 	for c := range chapters {
-		library.AddChapter(c)
+		progress.fileRead()
+		report.fileRead(c.url())
+
+		addChapter(c)
 	}
 }
 
-// Scan scans the given directory for audio files, uses the sorter to organize them into audiobooks
-// and adds them to the given library
-func Scan(rootDir string, library *library.AudioBookLibrary, sorter Sorter[RelativeAudioBookChapter]) []error {
-	audioFilesToScan := make(chan string, 100)
+// bookSortedReporter returns a handler that reports each sorted book to the progress, errors
+// and report, then passes it to onBook if it was sorted
+func bookSortedReporter(progress *progressTracker, errors *scanErrorCollector, report *ScanReport, onBook BookHandler) bookSortedHandler {
+	return func(unsortedBook *UnsortedBook, book *library.AudioBook, sortedBy string, bookErrors []*ScanError) {
+		progress.bookSorted()
+
+		for _, err := range bookErrors {
+			errors.add(err)
+		}
+
+		if report != nil {
+			report.addBook(newBookReport(unsortedBook, book, sortedBy, bookErrors))
+
+			// Files are reported in the book they ended up in, after merges and renames
+			if book != nil {
+				paths := make([]string, len(unsortedBook.Chapters))
+				for i := range unsortedBook.Chapters {
+					paths[i] = unsortedBook.Chapters[i].url()
+				}
+				report.bookFiles(book.Title, paths)
+			}
+		}
+
+		if book != nil {
+			onBook(*book)
+		}
+	}
+}
+
+// newBookReport describes the outcome of sorting the book
+func newBookReport(unsortedBook *UnsortedBook, book *library.AudioBook, sortedBy string, bookErrors []*ScanError) BookReport {
+	bookReport := BookReport{
+		Title:           unsortedBook.BookTitle,
+		Chapters:        len(unsortedBook.Chapters),
+		Sorter:          sortedBy,
+		Added:           book != nil,
+		DuplicateTracks: duplicateTracks(unsortedBook.Chapters),
+		Disagreements:   unsortedBook.disagreements(),
+	}
+
+	if book != nil {
+		bookReport.Author = book.Author
+		bookReport.Authors = unsortedBook.Authors()
+	}
+
+	roots := make(map[string]struct{})
+	for _, chapter := range unsortedBook.Chapters {
+		if chapter.root != "" {
+			roots[chapter.root] = struct{}{}
+		}
+	}
+
+	for root := range roots {
+		bookReport.Roots = append(bookReport.Roots, root)
+	}
+	sort.Strings(bookReport.Roots)
+
+	for _, err := range bookErrors {
+		bookReport.Errors = append(bookReport.Errors, err.Err.Error())
+	}
+
+	return bookReport
+}
+
+// A root being scanned
+type scanRoot struct {
+	Root
+	// The root's position in the scan
+	index int
+	// Reads the chapter at a path in the root
+	readChapter chapterReader
+}
+
+// A file found in one of the roots of a scan
+type rootFile struct {
+	root *scanRoot
+	path string
+	// Where the file is stored, if the schedule needs it
+	location fileLocation
+}
+
+// scanFS scans the roots for audio files, passing each book to onBook once the mode allows
+func scanFS(ctx context.Context, roots []Root, sorter Sorter[RelativeAudioBookChapter], mode StreamMode, onBook BookHandler, options ScanOptions) []error {
+	audioFilesFound := make(chan rootFile, 100)
 	chapters := make(chan RelativeAudioBookChapter)
+
+	var progress progressTracker
+	progress.Initialize(options.OnProgress)
+
+	var errors scanErrorCollector
+	errors.Initialize(options.OnError)
+
+	report := options.Report
+	report.start()
+	scanStart := time.Now()
+
+	scanRoots := make([]scanRoot, len(roots))
+	for i, root := range resolveRoots(roots) {
+		scanRoots[i].Root = root
+		scanRoots[i].FS = withContext(ctx, root.FS)
+		scanRoots[i].index = i
+
+		if options.OpenZipArchives {
+			var closeArchives func()
+			scanRoots[i].FS, closeArchives = openZipArchives(scanRoots[i].FS)
+			defer closeArchives()
+		}
+	}
+
+	// Roots are read by the same workers, so use enough for the one that needs the most
+	workers := options.Workers
+	if workers <= 0 {
+		for _, root := range scanRoots {
+			if rootWorkers := DefaultWorkers(root.FS); rootWorkers > workers {
+				workers = rootWorkers
+			}
+		}
+	}
+
+	// Chapters and errors record paths joined with the root
+	chapterPath := func(root *scanRoot, path string) string {
+		return chapterURL(root.Path, fileURL(root.FS, path))
+	}
+
+	grouper := options.Grouper
+	if grouper == nil {
+		grouper = GroupByTags
+	}
+
+	// Chapters are grouped one by one as they're read, so their grouping times are added up
+	if report != nil {
+		groupChapter := grouper
+		grouper = func(chapter *RelativeAudioBookChapter) (BookKey, bool) {
+			groupStart := time.Now()
+			defer report.recordTimings(func(timings *ScanTimings) { timings.Group += time.Since(groupStart) })
+
+			return groupChapter(chapter)
+		}
+	}
+
+	onBookSorted := bookSortedReporter(&progress, &errors, report, onBook)
+	sortBooks := func(books *UnsortedBookLibrary) {
+		groupStart := time.Now()
+		merges := books.mergeSimilarTitles(options.MergeSimilarTitles)
+		progress.booksMerged(len(merges))
+		report.addMerges(merges)
+		report.addCollisions(books.titleCollisions())
+		report.recordTimings(func(timings *ScanTimings) { timings.Group += time.Since(groupStart) })
+
+		sortStart := time.Now()
+		books.sortBooks(sorter, onBookSorted)
+		report.recordTimings(func(timings *ScanTimings) { timings.Sort += time.Since(sortStart) })
+	}
+
+	// Books are either grouped across every root, or streamed from each directory when it's done
 	var unsortedLibrary UnsortedBookLibrary
-	unsortedLibrary.Initialize()
+	unsortedLibrary.InitializeGroupedBy(grouper)
+	addChapter := func(chapter RelativeAudioBookChapter) {
+		if unsortedLibrary.AddChapter(chapter) {
+			progress.bookGrouped()
+		}
+	}
 
-	filter := func(path string, d fs.DirEntry) (bool, error) {
-		info, err := d.Info()
-		if err != nil {
-			return false, err
+	var streamer *directoryStreamer
+	if mode == StreamByDirectory {
+		streamer = &directoryStreamer{}
+		streamer.Initialize(&progress, grouper, sortBooks)
+		addChapter = streamer.fileRead
+	}
+
+	walkRoot := func(root *scanRoot) {
+		filter := func(path string, d fs.DirEntry) (bool, error) {
+			info, err := d.Info()
+			if err != nil {
+				return false, err
+			}
+
+			if !isSupportedAudioFile(info) {
+				if !info.IsDir() {
+					report.fileSkipped(chapterPath(root, path), SkipExtension)
+				}
+
+				return false, nil
+			}
+
+			return true, nil
+		}
+
+		onError := func(path string, d fs.DirEntry, err error) error {
+			scanErr := &ScanError{Path: chapterPath(root, path), Stage: StageWalk, Err: err}
+			errors.add(scanErr)
+			report.fileFailed(scanErr)
+
+			// Scanning errors should be non-fatal
+			return nil
 		}
 
-		return isSupportedAudioFile(info), nil
+		onFile := func(path string, d fs.DirEntry) {
+			// Counted before sending, so files are never read before they're found
+			progress.fileFound()
+			streamer.fileFound(root.Path, path, chapterPath(root, path))
+
+			file := rootFile{root: root, path: path}
+			if options.Schedule.needsLocation() {
+				file.location = locateFile(root.index, path, d)
+			}
+
+			select {
+			case audioFilesFound <- file:
+			case <-ctx.Done():
+			}
+		}
+
+		walkOptions := WalkOptions{
+			IgnorePatterns: options.IgnorePatterns,
+			JunkPatterns:   options.JunkPatterns,
+			FollowSymlinks: options.FollowSymlinks,
+			Concurrency:    options.WalkConcurrency,
+			OnSkipped: func(path string, d fs.DirEntry, reason SkipReason) {
+				report.fileSkipped(chapterPath(root, path), reason)
+			},
+			OnDirDone: func(path string) {
+				streamer.dirWalked(root.Path, path)
+			},
+		}
+
+		walkFS(ctx, root.FS, walkOptions, filter, onFile, onError)
+	}
+
+	var filesThrottle, bytesThrottle *throttle
+	if options.Schedule.FilesPerSecond > 0 {
+		filesThrottle = &throttle{}
+		filesThrottle.Initialize(options.Schedule.FilesPerSecond)
+	}
+	if options.Schedule.BytesPerSecond > 0 {
+		bytesThrottle = &throttle{}
+		bytesThrottle.Initialize(float64(options.Schedule.BytesPerSecond))
 	}
 
-	onError := func(path string, d fs.DirEntry, err error) error {
-		fmt.Errorf("Failed to enumerate: %s, %s", path, err)
+	for i := range scanRoots {
+		root := &scanRoots[i]
+
+		// Files found in the tag cache aren't read, so they aren't throttled
+		readFS := newThrottledFS(ctx, root.FS, filesThrottle, bytesThrottle)
+		root.readChapter = func(path string) (RelativeAudioBookChapter, error) {
+			return fromFS(readFS, path, root.Path, fileURL(root.FS, path))
+		}
 
-		// Scanning errors should be non-fatal
-		return nil
+		if options.TagCache != nil {
+			root.readChapter = options.TagCache.cachedChapterReader(root.FS, root.Path, root.readChapter)
+		}
 	}
 
-	onFile := func(path string, d fs.DirEntry) {
-		audioFilesToScan <- path
+	var devices *deviceLimiter
+	if options.Schedule.OpensPerDevice > 0 {
+		devices = &deviceLimiter{}
+		devices.Initialize(options.Schedule.OpensPerDevice)
 	}
 
-	onScanError := func(path string, err error) {
-		fmt.Errorf("Failed to scan: %s, %s", path, err)
+	authorSeparators := authorSeparatorPattern(options.AuthorSeparators)
+	readChapter := func(file rootFile) (RelativeAudioBookChapter, error) {
+		release, err := devices.acquire(ctx, file.location)
+		if err != nil {
+			return RelativeAudioBookChapter{}, err
+		}
+		defer release()
+
+		chapter, err := file.root.readChapter(file.path)
+		chapter.splitAuthors(authorSeparators)
+		if chapter.filePath != file.path {
+			chapter.name = file.path
+		}
+
+		return chapter, err
+	}
+
+	onScanError := func(file rootFile, err error) {
+		progress.fileFailed()
+
+		scanErr, ok := err.(*ScanError)
+		if !ok {
+			scanErr = &ScanError{Path: chapterPath(file.root, file.path), Stage: StageOpen, Err: err}
+		}
+		errors.add(scanErr)
+		report.fileFailed(scanErr)
+		streamer.fileFailed(file.root.Path, file.path, chapterPath(file.root, file.path))
 	}
 
 	go func() {
-		Walk(rootDir, filter, onFile, onError)
-		close(audioFilesToScan)
+		for i := range scanRoots {
+			walkRoot(&scanRoots[i])
+		}
+		close(audioFilesFound)
+		progress.walkDone()
+		report.recordTimings(func(timings *ScanTimings) { timings.Walk = time.Since(scanStart) })
 	}()
-	go startFileScanners(7, audioFilesToScan, chapters, onScanError)
-	importIntoUnsortedLibrary(&unsortedLibrary, chapters)
 
-	return unsortedLibrary.AddAllToAudioBookLibrary(library, sorter)
+	audioFilesToScan := audioFilesFound
+	if options.Schedule.Ordered {
+		orderedFiles := make(chan rootFile, 100)
+		location := func(file rootFile) fileLocation { return file.location }
+		go orderFiles(ctx, options.Schedule.orderBatch(), location, audioFilesFound, orderedFiles)
+		audioFilesToScan = orderedFiles
+	}
+	go startFileScanners(ctx, workers, readChapter, audioFilesToScan, chapters, onScanError)
+	importChapters(chapters, addChapter, &progress, report)
+	report.recordTimings(func(timings *ScanTimings) { timings.Read = time.Since(scanStart) })
+
+	// Books read before a cancellation are still added to the library
+	if streamer != nil {
+		streamer.flush()
+	} else {
+		progress.sortingStarted()
+		sortBooks(&unsortedLibrary)
+	}
+	report.finish()
+
+	scanErrors := errors.collected()
+	if ctx.Err() != nil {
+		scanErrors = append(scanErrors, ctx.Err())
+	}
+
+	return scanErrors
+}
+
+// ScanContext scans fsys like ScanFS, but stops walking and reading tags once ctx is cancelled.
+// The books read before the cancellation are added to the library, and ctx.Err() is returned
+// with the other errors.
+func ScanContext(ctx context.Context, fsys fs.FS, library *library.AudioBookLibrary, sorter Sorter[RelativeAudioBookChapter], options ScanOptions) []error {
+	return scanFS(ctx, []Root{{FS: fsys}}, sorter, StreamByTags, library.Add, options)
+}
+
+// ScanFS scans the given filesystem for audio files, uses the sorter to organize them into audiobooks
+// and adds them to the given library.  Chapter urls are paths relative to the root of fsys.
+// fsys isn't closed, so filesystems holding files open, like ZipFS, must be closed by the caller.
+// See ScanOptions.OpenZipArchives for a ZipFS that's closed with the scan.
+func ScanFS(fsys fs.FS, library *library.AudioBookLibrary, sorter Sorter[RelativeAudioBookChapter]) []error {
+	return ScanContext(context.Background(), fsys, library, sorter, ScanOptions{})
+}
+
+// Scan scans the given directory for audio files, uses the sorter to organize them into audiobooks
+// and adds them to the given library
+func Scan(rootDir string, library *library.AudioBookLibrary, sorter Sorter[RelativeAudioBookChapter]) []error {
+	return scanFS(context.Background(), []Root{{Path: rootDir}}, sorter, StreamByTags, library.Add, ScanOptions{})
 }
 
 // ScanToNewLibrary scans the given directory for audio files, uses the sorter to organize them into audiobooks
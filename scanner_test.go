@@ -1,10 +1,19 @@
 package scanner
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io/fs"
 	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
 
 	"github.com/go-test/deep"
 	library "github.com/themooer1/audiobook-library"
@@ -42,6 +51,7 @@ func Test_fileScanner(t *testing.T) {
 						discNum:    0,
 						trackNum:   1,
 						filePath:   "./testdata/audiobooks/frankenstein/frankenstein_00_shelley_64kb.mp3",
+						fileSize:   245760,
 					}: {},
 				},
 			},
@@ -61,6 +71,7 @@ func Test_fileScanner(t *testing.T) {
 						discNum:    0,
 						trackNum:   1,
 						filePath:   "./testdata/audiobooks/frankenstein/frankenstein_00_shelley_64kb.mp3",
+						fileSize:   245760,
 					}: {},
 					{
 						title:      "01 - Chapter 1",
@@ -69,6 +80,7 @@ func Test_fileScanner(t *testing.T) {
 						discNum:    0,
 						trackNum:   2,
 						filePath:   "./testdata/audiobooks/frankenstein/frankenstein_01_shelley_64kb.mp3",
+						fileSize:   245760,
 					}: {},
 				},
 			},
@@ -89,6 +101,7 @@ func Test_fileScanner(t *testing.T) {
 						discNum:    0,
 						trackNum:   1,
 						filePath:   "./testdata/audiobooks/frankenstein/frankenstein_00_shelley_64kb.mp3",
+						fileSize:   245760,
 					}: {},
 					{
 						title:      "01 - Chapter 1",
@@ -97,6 +110,7 @@ func Test_fileScanner(t *testing.T) {
 						discNum:    0,
 						trackNum:   2,
 						filePath:   "./testdata/audiobooks/frankenstein/frankenstein_01_shelley_64kb.mp3",
+						fileSize:   245760,
 					}: {},
 					{
 						title:      "00 - Preface",
@@ -105,6 +119,7 @@ func Test_fileScanner(t *testing.T) {
 						discNum:    0,
 						trackNum:   1,
 						filePath:   "./testdata/audiobooks/crimeandpunishment/crimepunishment_00_dostoyevsky_64kb.mp3",
+						fileSize:   245760,
 					}: {},
 				},
 			},
@@ -126,7 +141,7 @@ func Test_fileScanner(t *testing.T) {
 			}
 			close(filesIn)
 
-			go fileScanner(filesIn, chaptersOut, errorHandler, &wg)
+			go fileScanner(context.Background(), fromFile, filesIn, chaptersOut, errorHandler, &wg)
 
 			go func() {
 				wg.Wait()
@@ -997,3 +1012,181 @@ func TestScan(t *testing.T) {
 		})
 	}
 }
+
+func mapFSFromDir(t *testing.T, dir string, names ...string) fstest.MapFS {
+	fsys := fstest.MapFS{}
+
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fsys[name] = &fstest.MapFile{Data: data}
+	}
+
+	return fsys
+}
+
+// id3File returns an mp3 file with nothing but an ID3v2.3 tag naming its album, artist and track
+func id3File(album string, artist string, track int) *fstest.MapFile {
+	var frames bytes.Buffer
+	for _, frame := range [][2]string{{"TALB", album}, {"TPE1", artist}, {"TRCK", strconv.Itoa(track)}} {
+		// The size includes the text's ISO-8859-1 encoding byte
+		size := len(frame[1]) + 1
+		frames.WriteString(frame[0])
+		frames.Write([]byte{byte(size >> 24), byte(size >> 16), byte(size >> 8), byte(size), 0, 0, 0})
+		frames.WriteString(frame[1])
+	}
+
+	// The tag's size is stored in 7 bits per byte
+	size := frames.Len()
+	header := []byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+
+	return &fstest.MapFile{Data: append(header, frames.Bytes()...)}
+}
+
+func TestScanFS(t *testing.T) {
+	fsys := mapFSFromDir(
+		t,
+		"testdata/audiobooks",
+		"frankenstein/frankenstein_01_shelley_64kb.mp3",
+		"frankenstein/frankenstein_00_shelley_64kb.mp3",
+		"crimeandpunishment/crimepunishment_00_dostoyevsky_64kb.mp3",
+	)
+
+	lib := library.AudioBookLibrary{}
+	lib.Initialize()
+
+	errors := ScanFS(fsys, &lib, SortByDiscNumber[RelativeAudioBookChapter])
+	if len(errors) > 0 {
+		t.Errorf("ScanFS() returned errors: %v", errors)
+	}
+
+	want := map[string]library.AudioBook{
+		"Frankenstein": {
+			Title:       "Frankenstein",
+			Author:      "Mary W. Shelley",
+			Description: "Description not available",
+			Chapters: []library.AudioBookChapter{
+				{Title: "00 - Letters", Index: 0, Url: "frankenstein/frankenstein_00_shelley_64kb.mp3"},
+				{Title: "01 - Chapter 1", Index: 1, Url: "frankenstein/frankenstein_01_shelley_64kb.mp3"},
+			},
+		},
+		"Crime and Punishment (Version 3)": {
+			Title:       "Crime and Punishment (Version 3)",
+			Author:      "Fyodor Dostoyevsky",
+			Description: "Description not available",
+			Chapters: []library.AudioBookChapter{
+				{Title: "00 - Preface", Index: 0, Url: "crimeandpunishment/crimepunishment_00_dostoyevsky_64kb.mp3"},
+			},
+		},
+	}
+
+	if diff := deep.Equal(want, lib.AudioBooksByName); diff != nil {
+		for _, d := range diff {
+			t.Error(d)
+		}
+	}
+}
+
+func TestScan_SortByFilename(t *testing.T) {
+	// SortByFilename returns an empty, but not nil, slice of errors
+	rootDir := t.TempDir()
+	for name, file := range mapFSFromDir(
+		t,
+		"testdata/audiobooks",
+		"frankenstein/frankenstein_01_shelley_64kb.mp3",
+		"frankenstein/frankenstein_00_shelley_64kb.mp3",
+	) {
+		if err := os.WriteFile(filepath.Join(rootDir, path.Base(name)), file.Data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lib := library.AudioBookLibrary{}
+	lib.Initialize()
+
+	errors := Scan(rootDir, &lib, SortByFilename[RelativeAudioBookChapter])
+	if len(errors) > 0 {
+		t.Errorf("Scan() returned errors: %v", errors)
+	}
+
+	if len(lib.AudioBooksByName) != 1 {
+		t.Fatalf("Library has books %v, want Frankenstein", lib.AudioBooksByName)
+	}
+
+	var titles []string
+	for _, chapter := range lib.Get("Frankenstein").Chapters {
+		titles = append(titles, chapter.Title)
+	}
+
+	if want := []string{"00 - Letters", "01 - Chapter 1"}; !reflect.DeepEqual(titles, want) {
+		t.Errorf("Frankenstein has chapters %v, want %v", titles, want)
+	}
+}
+
+// Cancels a scan once the given number of files have been opened
+type cancellingFS struct {
+	fs.FS
+	cancel    context.CancelFunc
+	remaining int32
+}
+
+func (c *cancellingFS) Open(name string) (fs.File, error) {
+	if hasSupportedAudioFileExtension(name) && atomic.AddInt32(&c.remaining, -1) == 0 {
+		c.cancel()
+	}
+
+	return c.FS.Open(name)
+}
+
+func TestScanContext(t *testing.T) {
+	type args struct {
+		workers int
+		// Files opened before the scan is cancelled, or zero to never cancel
+		cancelAfter int32
+	}
+	tests := []struct {
+		name         string
+		args         args
+		wantCanceled bool
+		// Upper bound on the chapters found, since workers may finish the files they started
+		maxChapters int
+	}{
+		{"One Worker", args{workers: 1}, false, 22},
+		{"Default Workers", args{workers: 0}, false, 22},
+		{"Cancelled", args{workers: 1, cancelAfter: 2}, true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			fsys := &cancellingFS{FS: os.DirFS("testdata/audiobooks/frankenstein"), cancel: cancel, remaining: tt.args.cancelAfter}
+
+			lib := library.AudioBookLibrary{}
+			lib.Initialize()
+
+			errors := ScanContext(ctx, fsys, &lib, SortByDiscNumber[RelativeAudioBookChapter], ScanOptions{Workers: tt.args.workers})
+
+			canceled := false
+			for _, err := range errors {
+				if err == context.Canceled {
+					canceled = true
+				} else {
+					t.Errorf("ScanContext() returned unexpected error: %v", err)
+				}
+			}
+
+			if canceled != tt.wantCanceled {
+				t.Errorf("ScanContext() cancelled = %v, want %v", canceled, tt.wantCanceled)
+			}
+
+			chapters := len(lib.Get("Frankenstein").Chapters)
+			if chapters == 0 || chapters > tt.maxChapters || (!tt.wantCanceled && chapters != tt.maxChapters) {
+				t.Errorf("ScanContext() found %d chapters, want at most %d", chapters, tt.maxChapters)
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package scanner
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Number of files found before they're ordered and read, if IOSchedule.OrderBatch is zero
+const DefaultOrderBatch = 256
+
+// IOSchedule orders and limits a scan's tag reads to suit its storage.  The zero value
+// reads files in the order they're found, as fast as the workers can.
+type IOSchedule struct {
+	// Whether to read files in order of directory and inode, rather than in the order they're
+	// found, so spinning disks seek less.  Filesystems usually give files in the same
+	// directory nearby inodes, allocated in the order the files were written.
+	Ordered bool
+	// Number of files found before they're ordered and read, see Ordered.  If zero,
+	// DefaultOrderBatch is used, and if negative, every file is found before any are read.
+	OrderBatch int
+	// Most files read at once from each device.  Files on filesystems without device numbers,
+	// see fileInode, share a limit for each root.  If zero, only Workers limits them.
+	OpensPerDevice int
+	// Most bytes read per second, or zero for no limit.  Throttled files aren't mmapped, so only
+	// the bytes the tag reader asks for are counted.
+	BytesPerSecond int
+	// Most files opened per second, or zero for no limit
+	FilesPerSecond float64
+}
+
+// SpinningDiskSchedule reads one file at a time from each disk, in the order they're laid out
+var SpinningDiskSchedule = IOSchedule{Ordered: true, OpensPerDevice: 1}
+
+// orderBatch returns the number of files ordered at once, or zero to order them all, see OrderBatch
+func (s *IOSchedule) orderBatch() int {
+	switch {
+	case s.OrderBatch == 0:
+		return DefaultOrderBatch
+	case s.OrderBatch < 0:
+		return 0
+	default:
+		return s.OrderBatch
+	}
+}
+
+// needsLocation reports whether the schedule needs to know where files are stored
+func (s *IOSchedule) needsLocation() bool {
+	return s.Ordered || s.OpensPerDevice > 0
+}
+
+// Where a file is stored, as far as the scheduler can tell
+type fileLocation struct {
+	// The index of the file's root in the scan, so files from each root are kept together
+	root   int
+	device uint64
+	inode  uint64
+	dir    string
+}
+
+// locateFile returns where the file d found at name in the root is stored
+func locateFile(root int, name string, d fs.DirEntry) fileLocation {
+	location := fileLocation{root: root, dir: path.Dir(name)}
+
+	if info, err := d.Info(); err == nil {
+		location.device, location.inode = fileInode(info)
+	}
+
+	return location
+}
+
+// fileLocationLess orders files by device, then root, then directory, then inode
+func fileLocationLess(a fileLocation, b fileLocation) bool {
+	if a.device != b.device {
+		return a.device < b.device
+	}
+
+	if a.root != b.root {
+		return a.root < b.root
+	}
+
+	if a.dir != b.dir {
+		return a.dir < b.dir
+	}
+
+	return a.inode < b.inode
+}
+
+// orderFiles passes the files from filesIn to filesOut in batches of batchSize, or in one batch
+// if it's zero, each ordered by where its files are stored, until filesIn is closed or ctx is cancelled
+func orderFiles[F any](ctx context.Context, batchSize int, location func(file F) fileLocation, filesIn <-chan F, filesOut chan<- F) {
+	defer close(filesOut)
+
+	var batch []F
+	flush := func() bool {
+		sort.SliceStable(batch, func(i, j int) bool {
+			return fileLocationLess(location(batch[i]), location(batch[j]))
+		})
+
+		for _, file := range batch {
+			select {
+			case filesOut <- file:
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		batch = batch[:0]
+		return true
+	}
+
+	for file := range filesIn {
+		batch = append(batch, file)
+
+		if batchSize > 0 && len(batch) >= batchSize {
+			if !flush() {
+				return
+			}
+		}
+	}
+
+	flush()
+}
+
+// deviceLimiter limits how many files are read at once from each device
+type deviceLimiter struct {
+	limit int
+
+	mutex sync.Mutex
+	// A semaphore for each device, holding a value for each file being read from it
+	devices map[fileLocation]chan struct{}
+}
+
+func (l *deviceLimiter) Initialize(limit int) {
+	l.limit = limit
+	l.devices = make(map[fileLocation]chan struct{})
+}
+
+// semaphore returns the semaphore for the device location is on
+func (l *deviceLimiter) semaphore(location fileLocation) chan struct{} {
+	// Without a device number the root is the best guess at the device
+	key := fileLocation{device: location.device}
+	if location.device == 0 && location.inode == 0 {
+		key.root = location.root
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	semaphore, ok := l.devices[key]
+	if !ok {
+		semaphore = make(chan struct{}, l.limit)
+		l.devices[key] = semaphore
+	}
+
+	return semaphore
+}
+
+// acquire waits until a file at location can be read, and returns the function that
+// releases it once it's been read, or ctx's error if it's cancelled first.  A nil limiter
+// doesn't limit reads.
+func (l *deviceLimiter) acquire(ctx context.Context, location fileLocation) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	semaphore := l.semaphore(location)
+	select {
+	case semaphore <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	return func() { <-semaphore }, nil
+}
+
+// throttle paces events to a rate, shared by every goroutine using it
+type throttle struct {
+	// Events per second
+	rate float64
+
+	mutex sync.Mutex
+	// When the next event is allowed
+	next time.Time
+}
+
+func (t *throttle) Initialize(rate float64) {
+	t.rate = rate
+}
+
+// wait blocks until n more events are allowed by the rate, or until ctx is cancelled.
+// A nil throttle never waits.
+func (t *throttle) wait(ctx context.Context, n int) {
+	if t == nil || n <= 0 {
+		return
+	}
+
+	t.mutex.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	start := t.next
+	t.next = t.next.Add(time.Duration(float64(n) / t.rate * float64(time.Second)))
+	t.mutex.Unlock()
+
+	// Events are allowed up front, so the wait pays for the events before them
+	delay := start.Sub(now)
+	if delay <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// throttledFS limits the rate files are opened and read from the filesystem it wraps
+type throttledFS struct {
+	fs.FS
+	ctx   context.Context
+	files *throttle
+	bytes *throttle
+}
+
+// newThrottledFS wraps fsys in the schedule's throttles, or returns it as it is if the schedule
+// doesn't throttle reads.  Waits for the throttles end early once ctx is cancelled.
+func newThrottledFS(ctx context.Context, fsys fs.FS, files *throttle, bytes *throttle) fs.FS {
+	if files == nil && bytes == nil {
+		return fsys
+	}
+
+	return &throttledFS{FS: fsys, ctx: ctx, files: files, bytes: bytes}
+}
+
+func (t *throttledFS) Open(name string) (fs.File, error) {
+	t.files.wait(t.ctx, 1)
+
+	file, err := t.FS.Open(name)
+	if err != nil || t.bytes == nil {
+		return file, err
+	}
+
+	throttled := throttledFile{File: file, fsys: t}
+	if seeker, ok := file.(io.Seeker); ok {
+		return &throttledSeekFile{throttled, seeker}, nil
+	}
+
+	return &throttled, nil
+}
+
+// throttledFile counts the bytes read from a file against its filesystem's throttle
+type throttledFile struct {
+	fs.File
+	fsys *throttledFS
+}
+
+func (f *throttledFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.fsys.bytes.wait(f.fsys.ctx, n)
+
+	return n, err
+}
+
+// throttledSeekFile is a throttledFile that can seek, like the file it wraps
+type throttledSeekFile struct {
+	throttledFile
+	seeker io.Seeker
+}
+
+func (f *throttledSeekFile) Seek(offset int64, whence int) (int64, error) {
+	return f.seeker.Seek(offset, whence)
+}
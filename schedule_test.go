@@ -0,0 +1,259 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	library "github.com/themooer1/audiobook-library"
+)
+
+func Test_orderFiles(t *testing.T) {
+	type args struct {
+		batchSize int
+		files     []fileLocation
+	}
+	tests := []struct {
+		name string
+		args args
+		want []fileLocation
+	}{
+		{
+			"By Directory Then Inode",
+			args{0, []fileLocation{{dir: "b", inode: 1}, {dir: "a", inode: 9}, {dir: "a", inode: 3}}},
+			[]fileLocation{{dir: "a", inode: 3}, {dir: "a", inode: 9}, {dir: "b", inode: 1}},
+		},
+		{
+			"By Device Then Root",
+			args{0, []fileLocation{{root: 0, device: 2, dir: "a"}, {root: 1, device: 1, dir: "b"}, {root: 0, device: 1, dir: "c"}}},
+			[]fileLocation{{root: 0, device: 1, dir: "c"}, {root: 1, device: 1, dir: "b"}, {root: 0, device: 2, dir: "a"}},
+		},
+		{
+			"In Batches",
+			args{2, []fileLocation{{inode: 4}, {inode: 3}, {inode: 2}, {inode: 1}, {inode: 0}}},
+			[]fileLocation{{inode: 3}, {inode: 4}, {inode: 1}, {inode: 2}, {inode: 0}},
+		},
+		{
+			"Without Inodes",
+			args{0, []fileLocation{{dir: "a", root: 1}, {dir: "a", root: 0}, {dir: "a", root: 1}}},
+			[]fileLocation{{dir: "a", root: 0}, {dir: "a", root: 1}, {dir: "a", root: 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filesIn := make(chan fileLocation, len(tt.args.files))
+			filesOut := make(chan fileLocation)
+
+			for _, file := range tt.args.files {
+				filesIn <- file
+			}
+			close(filesIn)
+
+			location := func(file fileLocation) fileLocation { return file }
+			go orderFiles(context.Background(), tt.args.batchSize, location, filesIn, filesOut)
+
+			var got []fileLocation
+			for file := range filesOut {
+				got = append(got, file)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("orderFiles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIOSchedule_orderBatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule IOSchedule
+		want     int
+	}{
+		{"Default", IOSchedule{Ordered: true}, DefaultOrderBatch},
+		{"Batch", IOSchedule{Ordered: true, OrderBatch: 10}, 10},
+		{"Whole Walk", IOSchedule{Ordered: true, OrderBatch: -1}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.schedule.orderBatch(); got != tt.want {
+				t.Errorf("orderBatch() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_deviceLimiter_acquire(t *testing.T) {
+	var devices deviceLimiter
+	devices.Initialize(1)
+
+	location := fileLocation{device: 1}
+	release, err := devices.acquire(context.Background(), location)
+	if err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+
+	// The device is busy, so the wait ends with the cancellation
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := devices.acquire(ctx, location); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("acquire() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	release()
+	if _, err := devices.acquire(context.Background(), location); err != nil {
+		t.Errorf("acquire() after release error = %v", err)
+	}
+}
+
+func Test_throttle(t *testing.T) {
+	type args struct {
+		rate   float64
+		events []int
+	}
+	tests := []struct {
+		name string
+		args args
+		// Least time the events should take
+		want time.Duration
+	}{
+		{"Files", args{100, []int{1, 1, 1, 1, 1}}, 40 * time.Millisecond},
+		{"Bytes", args{10000, []int{100, 500, 400}}, 60 * time.Millisecond},
+		{"Single Event", args{1, []int{1}}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var throttle throttle
+			throttle.Initialize(tt.args.rate)
+
+			start := time.Now()
+			for _, n := range tt.args.events {
+				throttle.wait(context.Background(), n)
+			}
+
+			// The last event is allowed straight away, and only delays the ones after it
+			if got := time.Since(start); got < tt.want || got > tt.want+time.Second {
+				t.Errorf("Events took %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("Cancelled", func(t *testing.T) {
+		var throttle throttle
+		throttle.Initialize(0.001)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		start := time.Now()
+		throttle.wait(ctx, 1)
+		throttle.wait(ctx, 1)
+
+		if got := time.Since(start); got > time.Second {
+			t.Errorf("Cancelled wait took %v", got)
+		}
+	})
+}
+
+// Records how many audio files are open at once
+type concurrentOpenFS struct {
+	fs.FS
+	mutex   sync.Mutex
+	open    int
+	maxOpen int
+}
+
+func (c *concurrentOpenFS) Open(name string) (fs.File, error) {
+	file, err := c.FS.Open(name)
+	if err != nil || !hasSupportedAudioFileExtension(name) {
+		return file, err
+	}
+
+	c.mutex.Lock()
+	c.open++
+	if c.open > c.maxOpen {
+		c.maxOpen = c.open
+	}
+	c.mutex.Unlock()
+
+	return &concurrentOpenFile{file, c}, nil
+}
+
+type concurrentOpenFile struct {
+	fs.File
+	fsys *concurrentOpenFS
+}
+
+func (f *concurrentOpenFile) Close() error {
+	f.fsys.mutex.Lock()
+	f.fsys.open--
+	f.fsys.mutex.Unlock()
+
+	return f.File.Close()
+}
+
+func TestScanContext_Schedule(t *testing.T) {
+	type args struct {
+		schedule IOSchedule
+	}
+	tests := []struct {
+		name        string
+		args        args
+		wantMaxOpen int
+	}{
+		{"Spinning Disk", args{SpinningDiskSchedule}, 1},
+		{"Ordered In Batches", args{IOSchedule{Ordered: true, OrderBatch: 3}}, 4},
+		{"Two Opens Per Device", args{IOSchedule{OpensPerDevice: 2}}, 2},
+		{"Throttled", args{IOSchedule{FilesPerSecond: 1000, BytesPerSecond: 100 << 20}}, 4},
+	}
+
+	mapFS := mapFSFromDir(
+		t,
+		"testdata/audiobooks",
+		"crimeandpunishment/crimepunishment_00_dostoyevsky_64kb.mp3",
+		"crimeandpunishment/crimepunishment_01_dostoyevsky_64kb.mp3",
+		"crimeandpunishment/crimepunishment_02_dostoyevsky_64kb.mp3",
+		"frankenstein/frankenstein_00_shelley_64kb.mp3",
+		"frankenstein/frankenstein_01_shelley_64kb.mp3",
+		"frankenstein/frankenstein_02_shelley_64kb.mp3",
+	)
+
+	want := library.AudioBookLibrary{}
+	want.Initialize()
+	if errors := ScanContext(context.Background(), mapFS, &want, SortByDiscNumber[RelativeAudioBookChapter], ScanOptions{}); len(errors) > 0 {
+		t.Fatalf("ScanContext() returned errors: %v", errors)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := &concurrentOpenFS{FS: mapFS}
+
+			lib := library.AudioBookLibrary{}
+			lib.Initialize()
+
+			errors := ScanContext(context.Background(), fsys, &lib, SortByDiscNumber[RelativeAudioBookChapter], ScanOptions{
+				Workers:  4,
+				Schedule: tt.args.schedule,
+			})
+			if len(errors) > 0 {
+				t.Errorf("ScanContext() returned errors: %v", errors)
+			}
+
+			if fsys.maxOpen > tt.wantMaxOpen {
+				t.Errorf("%d files were open at once, want at most %d", fsys.maxOpen, tt.wantMaxOpen)
+			}
+
+			if diff := deep.Equal(want.AudioBooksByName, lib.AudioBooksByName); diff != nil {
+				for _, d := range diff {
+					t.Error(d)
+				}
+			}
+		})
+	}
+}
@@ -2,6 +2,9 @@ package scanner
 
 import (
 	"errors"
+	"reflect"
+	"runtime"
+	"strings"
 )
 
 // type ChapterSorterStatus int32
@@ -30,6 +33,40 @@ func Compose[T any](sorters ...Sorter[T]) Sorter[T] {
 	}
 }
 
+// Named labels the chapters ordered by sorter with name, so scan reports can
+// tell which of the sorters passed to Compose ordered each book.
+func Named(name string, sorter Sorter[RelativeAudioBookChapter]) Sorter[RelativeAudioBookChapter] {
+	return func(chapters []RelativeAudioBookChapter) ([]RelativeAudioBookChapter, []error) {
+		sorted, errors := sorter(chapters)
+		if len(errors) == 0 {
+			for i := range sorted {
+				sorted[i].sortedBy = name
+			}
+		}
+
+		return sorted, errors
+	}
+}
+
+// sorterName returns the name of the function sorter is, like "SortByDiscNumber", for scan
+// reports about books whose sorter wasn't given one with Named
+func sorterName(sorter Sorter[RelativeAudioBookChapter]) string {
+	function := runtime.FuncForPC(reflect.ValueOf(sorter).Pointer())
+	if function == nil {
+		return ""
+	}
+
+	// Trim the package path, and the type parameters and closures after the function name
+	name := function.Name()
+	name = name[strings.LastIndex(name, "/")+1:]
+	name = name[strings.Index(name, ".")+1:]
+	if end := strings.IndexAny(name, "[."); end >= 0 {
+		name = name[:end]
+	}
+
+	return name
+}
+
 // func Compose(sorters ...ChapterSorter) ChapterSorter {
 // 	return func(chapters []RelativeAudioBookChapter) ([]RelativeAudioBookChapter, error) {
 // 		for _, sort := range sorters {
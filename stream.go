@@ -0,0 +1,183 @@
+package scanner
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"sort"
+	"sync"
+
+	library "github.com/themooer1/audiobook-library"
+)
+
+// BookHandler is called with each book a streaming scan finishes.  Calls are never
+// concurrent, but they block the scan, so handlers should return quickly.
+type BookHandler func(book library.AudioBook)
+
+// StreamMode says when a streaming scan can consider a book finished
+type StreamMode string
+
+const (
+	// Chapters are grouped into books within each directory, and a directory's books are
+	// streamed as soon as it's been walked and all of its files read.  This suits libraries
+	// with a directory per book, but books split across directories are streamed once per
+	// directory.  Books whose title was already streamed are numbered, like "Dune #2".
+	StreamByDirectory StreamMode = "directory"
+	// Chapters are grouped into books across the whole tree by their tags, so books
+	// are only streamed once every file has been read.
+	StreamByTags StreamMode = "tags"
+)
+
+// ScanStream scans fsys like ScanContext, but passes each book to onBook as soon as
+// the mode allows, instead of adding them all to a library at the end.
+func ScanStream(ctx context.Context, fsys fs.FS, sorter Sorter[RelativeAudioBookChapter], mode StreamMode, onBook BookHandler, options ScanOptions) []error {
+	return scanFS(ctx, []Root{{FS: fsys}}, sorter, mode, onBook, options)
+}
+
+// Identifies a directory in one of the roots of a scan
+type streamedDirectoryKey struct {
+	root string
+	dir  string
+}
+
+// The chapters read from one directory
+type streamedDirectory struct {
+	walked bool
+	// Files found but not yet read
+	pending int
+	books   UnsortedBookLibrary
+}
+
+// directoryStreamer sorts the books in each directory once the directory has been walked
+// and all of its files read.  The methods below are safe to call on a nil streamer, which does nothing.
+type directoryStreamer struct {
+	mutex       sync.Mutex
+	progress    *progressTracker
+	grouper     Grouper
+	sortBooks   func(books *UnsortedBookLibrary)
+	directories map[streamedDirectoryKey]*streamedDirectory
+	// The directory of each file being read, by chapter path
+	dirsByChapterPath map[string]streamedDirectoryKey
+	// The titles of the books streamed so far, so books in other directories don't replace them
+	titles map[BookTitle]struct{}
+}
+
+func (s *directoryStreamer) Initialize(progress *progressTracker, grouper Grouper, sortBooks func(books *UnsortedBookLibrary)) {
+	s.progress = progress
+	s.grouper = grouper
+	s.sortBooks = sortBooks
+	s.directories = make(map[streamedDirectoryKey]*streamedDirectory)
+	s.dirsByChapterPath = make(map[string]streamedDirectoryKey)
+	s.titles = make(map[BookTitle]struct{})
+}
+
+func (s *directoryStreamer) directory(dir streamedDirectoryKey) *streamedDirectory {
+	d, ok := s.directories[dir]
+	if !ok {
+		d = &streamedDirectory{}
+		d.books.InitializeGroupedBy(s.grouper)
+		d.books.takenTitles = s.titles
+		s.directories[dir] = d
+	}
+
+	return d
+}
+
+// sortIfDone sorts the directory's books once nothing more can be added to it
+func (s *directoryStreamer) sortIfDone(dir streamedDirectoryKey) {
+	d := s.directory(dir)
+	if !d.walked || d.pending > 0 {
+		return
+	}
+
+	delete(s.directories, dir)
+	s.sortBooks(&d.books)
+}
+
+// dirWalked is called once all of the entries in dir have been visited
+func (s *directoryStreamer) dirWalked(root string, dir string) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := streamedDirectoryKey{root, dir}
+	s.directory(key).walked = true
+	s.sortIfDone(key)
+}
+
+func (s *directoryStreamer) fileFound(root string, name string, chapterPath string) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	dir := streamedDirectoryKey{root, path.Dir(name)}
+	s.dirsByChapterPath[chapterPath] = dir
+	s.directory(dir).pending++
+}
+
+func (s *directoryStreamer) fileRead(chapter RelativeAudioBookChapter) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	dir := s.dirsByChapterPath[chapter.url()]
+	delete(s.dirsByChapterPath, chapter.url())
+
+	d := s.directory(dir)
+	d.pending--
+	if d.books.AddChapter(chapter) {
+		s.progress.bookGrouped()
+	}
+
+	s.sortIfDone(dir)
+}
+
+func (s *directoryStreamer) fileFailed(root string, name string, chapterPath string) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	dir := streamedDirectoryKey{root, path.Dir(name)}
+	delete(s.dirsByChapterPath, chapterPath)
+	s.directory(dir).pending--
+	s.sortIfDone(dir)
+}
+
+// flush sorts the books in directories that were never finished, because the scan was cancelled
+func (s *directoryStreamer) flush() {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	dirs := make([]streamedDirectoryKey, 0, len(s.directories))
+	for dir := range s.directories {
+		dirs = append(dirs, dir)
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		if dirs[i].root != dirs[j].root {
+			return dirs[i].root < dirs[j].root
+		}
+
+		return dirs[i].dir < dirs[j].dir
+	})
+
+	for _, dir := range dirs {
+		s.sortBooks(&s.directories[dir].books)
+		delete(s.directories, dir)
+	}
+}
@@ -0,0 +1,178 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	library "github.com/themooer1/audiobook-library"
+)
+
+// Holds back opening audio files under a directory until the gate is opened
+type gatedFS struct {
+	fs.FS
+	dir  string
+	gate chan struct{}
+}
+
+func (g *gatedFS) Open(name string) (fs.File, error) {
+	if strings.HasPrefix(name, g.dir+"/") && hasSupportedAudioFileExtension(name) {
+		select {
+		case <-g.gate:
+		case <-time.After(5 * time.Second):
+			return nil, errors.New("gate was never opened")
+		}
+	}
+
+	return g.FS.Open(name)
+}
+
+func TestScanStream(t *testing.T) {
+	fsys := mapFSFromDir(
+		t,
+		"testdata/audiobooks",
+		"crimeandpunishment/crimepunishment_00_dostoyevsky_64kb.mp3",
+		"crimeandpunishment/crimepunishment_01_dostoyevsky_64kb.mp3",
+		"frankenstein/frankenstein_00_shelley_64kb.mp3",
+		"frankenstein/frankenstein_01_shelley_64kb.mp3",
+	)
+	// The second disc of Frankenstein is in its own directory
+	fsys["frankenstein/disc2/frankenstein_02_shelley_64kb.mp3"] = mapFSFromDir(t, "testdata/audiobooks", "frankenstein/frankenstein_02_shelley_64kb.mp3")["frankenstein/frankenstein_02_shelley_64kb.mp3"]
+
+	type args struct {
+		mode            StreamMode
+		walkConcurrency int
+		// Whether Frankenstein can only be read once Crime and Punishment has been streamed,
+		// which only works if books are streamed before the scan finishes.  There are enough
+		// workers that Crime and Punishment is read while others wait for Frankenstein.
+		gated bool
+	}
+	tests := []struct {
+		name string
+		args args
+		// The chapters in each book streamed
+		want []string
+	}{
+		{
+			"By Directory",
+			args{mode: StreamByDirectory, gated: true},
+			[]string{"Crime and Punishment (Version 3): 2", "Frankenstein: 1", "Frankenstein: 2"},
+		},
+		{
+			"By Directory In Parallel",
+			args{mode: StreamByDirectory, walkConcurrency: 4, gated: true},
+			[]string{"Crime and Punishment (Version 3): 2", "Frankenstein: 1", "Frankenstein: 2"},
+		},
+		{
+			"By Tags",
+			args{mode: StreamByTags},
+			[]string{"Crime and Punishment (Version 3): 2", "Frankenstein: 3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gatedFS := &gatedFS{FS: fsys, dir: "frankenstein", gate: make(chan struct{})}
+			var gateOnce sync.Once
+			openGate := func() { gateOnce.Do(func() { close(gatedFS.gate) }) }
+			if !tt.args.gated {
+				openGate()
+			}
+
+			var got []string
+			titles := make(map[string]struct{})
+			onBook := func(book library.AudioBook) {
+				if _, ok := titles[book.Title]; ok {
+					t.Errorf("ScanStream() streamed %s twice", book.Title)
+				}
+				titles[book.Title] = struct{}{}
+
+				// Whichever half of Frankenstein is streamed second is numbered
+				got = append(got, fmt.Sprintf("%s: %d", strings.TrimSuffix(book.Title, " #2"), len(book.Chapters)))
+
+				if book.Title == "Crime and Punishment (Version 3)" {
+					openGate()
+				}
+			}
+
+			errors := ScanStream(context.Background(), gatedFS, SortByDiscNumber[RelativeAudioBookChapter], tt.args.mode, onBook, ScanOptions{Workers: 8, WalkConcurrency: tt.args.walkConcurrency})
+			if len(errors) > 0 {
+				t.Errorf("ScanStream() returned errors: %v", errors)
+			}
+
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ScanStream() streamed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanStream_SameTitleInTwoDirectories(t *testing.T) {
+	chapter := mapFSFromDir(t, "testdata/audiobooks", "frankenstein/frankenstein_00_shelley_64kb.mp3")["frankenstein/frankenstein_00_shelley_64kb.mp3"]
+	fsys := fstest.MapFS{
+		"a/frankenstein_00_shelley_64kb.mp3": chapter,
+		"b/frankenstein_00_shelley_64kb.mp3": chapter,
+	}
+
+	lib := library.AudioBookLibrary{}
+	lib.Initialize()
+
+	errors := ScanStream(context.Background(), fsys, SortByDiscNumber[RelativeAudioBookChapter], StreamByDirectory, lib.Add, ScanOptions{Workers: 1})
+	if len(errors) > 0 {
+		t.Errorf("ScanStream() returned errors: %v", errors)
+	}
+
+	var got []string
+	for title := range lib.AudioBooksByName {
+		got = append(got, title)
+	}
+	sort.Strings(got)
+
+	if want := []string{"Frankenstein", "Frankenstein #2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Library has books %v, want %v", got, want)
+	}
+}
+
+func TestScanStream_Deterministic(t *testing.T) {
+	// Keeps chapters in the order they're given, so the result depends on arrival order unless
+	// the scan fixes it, and fails the books it can't sort, so errors are returned
+	unsortedSorter := func(chapters []RelativeAudioBookChapter) ([]RelativeAudioBookChapter, []error) {
+		if chapters[0].bookTitle != "Frankenstein" {
+			return nil, []error{ErrCannotSort}
+		}
+
+		return chapters, nil
+	}
+
+	scan := func() []string {
+		var got []string
+		onBook := func(book library.AudioBook) {
+			for _, chapter := range book.Chapters {
+				got = append(got, book.Title+": "+chapter.Url)
+			}
+		}
+
+		errors := ScanStream(context.Background(), os.DirFS("testdata/audiobooks"), unsortedSorter, StreamByTags, onBook, ScanOptions{Workers: 8})
+		for _, err := range errors {
+			got = append(got, err.Error())
+		}
+
+		return got
+	}
+
+	want := scan()
+	for i := 0; i < 5; i++ {
+		if got := scan(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("Scan %d = %v, want %v", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package scanner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Bumped whenever the format of cached entries changes, so old caches are discarded
+const tagCacheVersion = 3
+
+// Identifies a version of a file, which changes whenever the file is modified or replaced
+type fileIdentity struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"`
+	Device  uint64 `json:"device,omitempty"`
+	Inode   uint64 `json:"inode,omitempty"`
+}
+
+func identifyFile(info fs.FileInfo) fileIdentity {
+	device, inode := fileInode(info)
+
+	return fileIdentity{
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano(),
+		Device:  device,
+		Inode:   inode,
+	}
+}
+
+// The tags read from one version of a file
+type tagCacheEntry struct {
+	Identity    fileIdentity `json:"identity"`
+	Title       string       `json:"title"`
+	BookTitle   string       `json:"bookTitle"`
+	BookAuthor  string       `json:"bookAuthor"`
+	AlbumArtist string       `json:"albumArtist,omitempty"`
+	ReleaseID   string       `json:"releaseId,omitempty"`
+	DiscNum     int          `json:"discNum"`
+	TrackNum    int          `json:"trackNum"`
+}
+
+type tagCacheFile struct {
+	Version int                      `json:"version"`
+	Entries map[string]tagCacheEntry `json:"entries"`
+}
+
+// TagCache remembers the tags read from each file, so rescans only read files that changed.
+// Entries are keyed by chapter url and invalidated when a file's size, modification time
+// or inode changes.  Entries for files that weren't seen since the cache was loaded are
+// kept until Prune is called.
+type TagCache struct {
+	path    string
+	mutex   sync.Mutex
+	entries map[string]tagCacheEntry
+	// Paths looked up or stored since the cache was loaded
+	seen map[string]struct{}
+}
+
+// Load reads the cache stored at path.  A missing file loads an empty cache.  If the file
+// can't be read the cache is still usable, starting empty, and the error is returned.
+func (c *TagCache) Load(path string) error {
+	c.path = path
+	c.entries = make(map[string]tagCacheEntry)
+	c.seen = make(map[string]struct{})
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var cacheFile tagCacheFile
+	if err := json.Unmarshal(data, &cacheFile); err != nil {
+		return fmt.Errorf("reading tag cache %s: %w", path, err)
+	}
+
+	if cacheFile.Version == tagCacheVersion && cacheFile.Entries != nil {
+		c.entries = cacheFile.Entries
+	}
+
+	return nil
+}
+
+// Prune removes entries for files that weren't seen since the cache was loaded.  Only call it
+// after a scan of every root that completed, since a cancelled, failed or partial scan
+// doesn't see files that still exist.
+func (c *TagCache) Prune() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for path := range c.entries {
+		if _, ok := c.seen[path]; !ok {
+			delete(c.entries, path)
+		}
+	}
+}
+
+// Save writes the cache back to the path it was loaded from
+func (c *TagCache) Save() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	data, err := json.Marshal(tagCacheFile{Version: tagCacheVersion, Entries: c.entries})
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so an interrupted save doesn't corrupt the cache
+	tempFile, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tempFile.Write(data)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tempFile.Name(), c.path)
+	}
+
+	if err != nil {
+		os.Remove(tempFile.Name())
+	}
+
+	return err
+}
+
+// get returns the cached chapter for the file at path in root, if it was cached for this version of the file
+func (c *TagCache) get(root string, path string, identity fileIdentity) (RelativeAudioBookChapter, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := chapterURL(root, path)
+	c.seen[key] = struct{}{}
+
+	entry, ok := c.entries[key]
+	if !ok || entry.Identity != identity {
+		return RelativeAudioBookChapter{}, false
+	}
+
+	return RelativeAudioBookChapter{
+		title:       entry.Title,
+		bookTitle:   entry.BookTitle,
+		bookAuthor:  entry.BookAuthor,
+		albumArtist: entry.AlbumArtist,
+		releaseID:   entry.ReleaseID,
+		discNum:     entry.DiscNum,
+		trackNum:    entry.TrackNum,
+		root:        root,
+		filePath:    path,
+		fileSize:    entry.Identity.Size,
+	}, true
+}
+
+func (c *TagCache) put(identity fileIdentity, chapter *RelativeAudioBookChapter) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := chapter.url()
+	c.seen[key] = struct{}{}
+	c.entries[key] = tagCacheEntry{
+		Identity:    identity,
+		Title:       chapter.title,
+		BookTitle:   chapter.bookTitle,
+		BookAuthor:  chapter.bookAuthor,
+		AlbumArtist: chapter.albumArtist,
+		ReleaseID:   chapter.releaseID,
+		DiscNum:     chapter.discNum,
+		TrackNum:    chapter.trackNum,
+	}
+}
+
+// cachedChapterReader wraps readChapter, which reads chapters from fsys scanned as root,
+// so it only reads files that aren't in the cache
+func (c *TagCache) cachedChapterReader(fsys fs.FS, root string, readChapter chapterReader) chapterReader {
+	return func(path string) (RelativeAudioBookChapter, error) {
+		filePath := fileURL(fsys, path)
+
+		info, err := fs.Stat(fsys, path)
+		if err != nil {
+			return RelativeAudioBookChapter{}, &ScanError{Path: chapterURL(root, filePath), Stage: StageOpen, Err: err}
+		}
+
+		identity := identifyFile(info)
+		if chapter, ok := c.get(root, filePath, identity); ok {
+			return chapter, nil
+		}
+
+		chapter, err := readChapter(path)
+		if err == nil {
+			c.put(identity, &chapter)
+		}
+
+		return chapter, err
+	}
+}
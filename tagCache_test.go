@@ -0,0 +1,160 @@
+package scanner
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	library "github.com/themooer1/audiobook-library"
+)
+
+// Counts the files opened in a filesystem, without counting stats
+type openCountingFS struct {
+	fs.FS
+	opened int32
+}
+
+func (o *openCountingFS) Open(name string) (fs.File, error) {
+	if hasSupportedAudioFileExtension(name) {
+		atomic.AddInt32(&o.opened, 1)
+	}
+
+	return o.FS.Open(name)
+}
+
+func (o *openCountingFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(o.FS, name)
+}
+
+func (o *openCountingFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(o.FS, name)
+}
+
+func scanWithTagCache(t *testing.T, fsys fs.FS, cachePath string) *library.AudioBookLibrary {
+	var cache TagCache
+	if err := cache.Load(cachePath); err != nil {
+		t.Fatal(err)
+	}
+
+	lib := library.AudioBookLibrary{}
+	lib.Initialize()
+
+	errors := ScanContext(context.Background(), fsys, &lib, SortByDiscNumber[RelativeAudioBookChapter], ScanOptions{TagCache: &cache})
+	if len(errors) > 0 {
+		t.Errorf("ScanContext() returned errors: %v", errors)
+	} else {
+		cache.Prune()
+	}
+
+	if err := cache.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &lib
+}
+
+func TestTagCache(t *testing.T) {
+	cachePath := filepath.Join(t.TempDir(), "tags.json")
+	files := mapFSFromDir(
+		t,
+		"testdata/audiobooks",
+		"frankenstein/frankenstein_00_shelley_64kb.mp3",
+		"frankenstein/frankenstein_01_shelley_64kb.mp3",
+		"crimeandpunishment/crimepunishment_00_dostoyevsky_64kb.mp3",
+	)
+
+	type rescan struct {
+		name string
+		// Changes the library before the rescan
+		change       func()
+		wantOpened   int32
+		wantChapters int
+	}
+	rescans := []rescan{
+		{"Cold Cache", func() {}, 3, 3},
+		{"Unchanged", func() {}, 0, 3},
+		{
+			"Modified File",
+			func() { files["frankenstein/frankenstein_01_shelley_64kb.mp3"].ModTime = time.Now() },
+			1,
+			3,
+		},
+		{
+			"Removed File",
+			func() { delete(files, "crimeandpunishment/crimepunishment_00_dostoyevsky_64kb.mp3") },
+			0,
+			2,
+		},
+	}
+	for _, tt := range rescans {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.change()
+
+			fsys := &openCountingFS{FS: files}
+			lib := scanWithTagCache(t, fsys, cachePath)
+
+			if fsys.opened != tt.wantOpened {
+				t.Errorf("Opened %d files, want %d", fsys.opened, tt.wantOpened)
+			}
+
+			chapters := 0
+			for _, book := range lib.AudioBooksByName {
+				chapters += len(book.Chapters)
+			}
+
+			if chapters != tt.wantChapters {
+				t.Errorf("Found %d chapters, want %d", chapters, tt.wantChapters)
+			}
+		})
+	}
+
+	// The removed file was pruned from the cache
+	var cache TagCache
+	if err := cache.Load(cachePath); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cache.entries) != 2 {
+		t.Errorf("Expected 2 cache entries after pruning, but got %v", cache.entries)
+	}
+}
+
+func TestTagCache_CancelledScan(t *testing.T) {
+	cachePath := filepath.Join(t.TempDir(), "tags.json")
+	files := mapFSFromDir(
+		t,
+		"testdata/audiobooks",
+		"frankenstein/frankenstein_00_shelley_64kb.mp3",
+		"frankenstein/frankenstein_01_shelley_64kb.mp3",
+	)
+	scanWithTagCache(t, files, cachePath)
+
+	var cache TagCache
+	if err := cache.Load(cachePath); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	lib := library.AudioBookLibrary{}
+	lib.Initialize()
+	ScanContext(ctx, files, &lib, SortByDiscNumber[RelativeAudioBookChapter], ScanOptions{TagCache: &cache})
+
+	if err := cache.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files the cancelled scan didn't see are still cached
+	var saved TagCache
+	if err := saved.Load(cachePath); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(saved.entries) != 2 {
+		t.Errorf("Expected 2 cache entries after a cancelled scan, but got %v", saved.entries)
+	}
+}
@@ -0,0 +1,246 @@
+package scanner
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// A reasonable threshold for MergeSimilarTitles, which merges titles that differ by a typo
+// or two but keeps shorter titles apart
+const DefaultTitleSimilarity = 0.9
+
+var (
+	// Bracketed notes about the recording rather than the book, like "(Unabridged)"
+	titleEditionNotes = regexp.MustCompile(`[(\[{][^)\]}]*\b(?:unabridged|abridged|audio ?book|retail|dramati[sz]ed|full cast|edition)\b[^)\]}]*[)\]}]`)
+	// The same notes without brackets
+	titleEditionMarkers = regexp.MustCompile(`\b(?:unabridged|abridged|audio ?book|retail|dramati[sz]ed)\b`)
+	// A trailing disc or part number, like " - Disc 1", " CD2" or " (Part 3 of 4)"
+	titleDiscSuffix = regexp.MustCompile(`(?:^|[^\pL\pN])(?:dis[ck]|cd|part|pt)\.?[ _-]*(\d+)(?:[ ]*(?:of|/)[ ]*\d+)?[^\pL\pN]*$`)
+	// Runs of punctuation and spaces
+	titleSeparators = regexp.MustCompile(`[^\pL\pN]+`)
+	titleNumbers    = regexp.MustCompile(`\pN+`)
+)
+
+// normalizeTitle folds the title's case and accents and strips disc and part suffixes and
+// edition markers from it, so variants of the same title normalize to the same string.  It
+// also returns the disc or part number it stripped, or zero.
+func normalizeTitle(title string) (string, int) {
+	// Decomposing splits accents from their letters, so they can be dropped
+	var decomposed strings.Builder
+	for _, r := range norm.NFKD.String(title) {
+		if !unicode.Is(unicode.Mn, r) {
+			decomposed.WriteRune(r)
+		}
+	}
+
+	normalized := cases.Fold().String(decomposed.String())
+	normalized = titleEditionNotes.ReplaceAllString(normalized, " ")
+
+	discNum := 0
+	if match := titleDiscSuffix.FindStringSubmatchIndex(normalized); match != nil {
+		discNum, _ = strconv.Atoi(normalized[match[2]:match[3]])
+		normalized = normalized[:match[0]]
+	}
+
+	normalized = titleEditionMarkers.ReplaceAllString(normalized, " ")
+	normalized = strings.TrimSpace(titleSeparators.ReplaceAllString(normalized, " "))
+
+	return normalized, discNum
+}
+
+// levenshtein returns the number of runes that must be inserted, deleted or substituted to turn a into b
+func levenshtein(a []rune, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			substitution := previous[j-1]
+			if a[i-1] != b[j-1] {
+				substitution++
+			}
+
+			current[j] = minInt(substitution, previous[j]+1, current[j-1]+1)
+		}
+
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+func minInt(values ...int) int {
+	least := values[0]
+	for _, value := range values[1:] {
+		if value < least {
+			least = value
+		}
+	}
+
+	return least
+}
+
+// titleSimilarity returns how similar two normalized titles are, from 0 to 1.  Titles with
+// different numbers in them, like the books of a series, are never similar.
+func titleSimilarity(a string, b string) float64 {
+	if a == b {
+		return 1
+	}
+
+	if strings.Join(titleNumbers.FindAllString(a, -1), " ") != strings.Join(titleNumbers.FindAllString(b, -1), " ") {
+		return 0
+	}
+
+	aRunes, bRunes := []rune(a), []rune(b)
+	longest := len(aRunes)
+	if len(bRunes) > longest {
+		longest = len(bRunes)
+	}
+
+	return 1 - float64(levenshtein(aRunes, bRunes))/float64(longest)
+}
+
+// TitleMerge records a book that was merged into another because their titles were similar
+type TitleMerge struct {
+	// The title of the book the chapters were merged into
+	Into string `json:"into"`
+	// The title of the book that was merged
+	From string `json:"from"`
+	// How similar the normalized titles were, from 0 to 1
+	Similarity float64 `json:"similarity"`
+	Chapters   int     `json:"chapters"`
+}
+
+// A book being considered for merging
+type mergeCandidate struct {
+	key   BookKey
+	name  BookKey
+	title string
+	// The disc number stripped from the title, see normalizeTitle
+	discNum int
+	// The candidate this one is merged into, see find
+	parent int
+}
+
+// find returns the index of the candidate the one at i is merged into
+func find(candidates []mergeCandidate, i int) int {
+	for candidates[i].parent != i {
+		candidates[i].parent = candidates[candidates[i].parent].parent
+		i = candidates[i].parent
+	}
+
+	return i
+}
+
+// mergeSimilarTitles merges books by the same author, in the same directory and release, whose
+// normalized titles are at least threshold similar, see titleSimilarity.  Each group of books
+// is merged into the one with the most chapters.  Chapters without a disc number get the one
+// stripped from their book's title, like "Disc 2".  It returns the merges in order of title.
+func (u *UnsortedBookLibrary) mergeSimilarTitles(threshold float64) []TitleMerge {
+	if threshold <= 0 {
+		return nil
+	}
+
+	// Only books that differ by title alone can be merged
+	byScope := make(map[BookKey][]mergeCandidate)
+	for _, book := range u.namedBooks() {
+		title, discNum := normalizeTitle(book.name.Title)
+		author, _ := normalizeTitle(book.name.Author)
+		scope := BookKey{Author: author, Directory: book.name.Directory, ReleaseID: book.name.ReleaseID}
+
+		candidates := byScope[scope]
+		byScope[scope] = append(candidates, mergeCandidate{key: book.key, name: book.name, title: title, discNum: discNum, parent: len(candidates)})
+	}
+
+	var merges []TitleMerge
+	for _, candidates := range byScope {
+		for i := range candidates {
+			for j := i + 1; j < len(candidates); j++ {
+				if titleSimilarity(candidates[i].title, candidates[j].title) >= threshold {
+					candidates[find(candidates, j)].parent = find(candidates, i)
+				}
+			}
+		}
+
+		// Groups of similar books, by the index of their first book
+		groups := make(map[int][]int)
+		for i := range candidates {
+			root := find(candidates, i)
+			groups[root] = append(groups[root], i)
+		}
+
+		for _, group := range groups {
+			if len(group) > 1 {
+				merges = append(merges, u.mergeBooks(candidates, group)...)
+			}
+		}
+	}
+
+	sort.Slice(merges, func(i, j int) bool {
+		if merges[i].Into != merges[j].Into {
+			return merges[i].Into < merges[j].Into
+		}
+
+		return merges[i].From < merges[j].From
+	})
+
+	return merges
+}
+
+// mergeBooks merges the group of candidates into the one with the most chapters
+func (u *UnsortedBookLibrary) mergeBooks(candidates []mergeCandidate, group []int) []TitleMerge {
+	// Candidates are in order of name, so ties go to the first
+	into := group[0]
+	for _, i := range group[1:] {
+		if len(u.books[candidates[i].key].Chapters) > len(u.books[candidates[into].key].Chapters) {
+			into = i
+		}
+	}
+
+	target := u.books[candidates[into].key]
+	inferDiscNums(target.Chapters, candidates[into].discNum)
+
+	var merges []TitleMerge
+	for _, i := range group {
+		if i == into {
+			continue
+		}
+
+		book := u.books[candidates[i].key]
+		inferDiscNums(book.Chapters, candidates[i].discNum)
+
+		target.Chapters = append(target.Chapters, book.Chapters...)
+		delete(u.books, candidates[i].key)
+
+		merges = append(merges, TitleMerge{
+			Into:       candidates[into].name.Title,
+			From:       candidates[i].name.Title,
+			Similarity: titleSimilarity(candidates[into].title, candidates[i].title),
+			Chapters:   len(book.Chapters),
+		})
+	}
+
+	u.books[candidates[into].key] = target
+
+	return merges
+}
+
+// inferDiscNums gives chapters without a disc number discNum
+func inferDiscNums(chapters []RelativeAudioBookChapter, discNum int) {
+	for i := range chapters {
+		if chapters[i].discNum == 0 {
+			chapters[i].discNum = discNum
+		}
+	}
+}
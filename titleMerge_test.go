@@ -0,0 +1,178 @@
+package scanner
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func Test_normalizeTitle(t *testing.T) {
+	tests := []struct {
+		name        string
+		title       string
+		want        string
+		wantDiscNum int
+	}{
+		{"Plain", "The Hobbit", "the hobbit", 0},
+		{"Edition Note", "The Hobbit (Unabridged)", "the hobbit", 0},
+		{"Edition Marker", "The Hobbit Unabridged", "the hobbit", 0},
+		{"Bracketed Edition", "The Hobbit [Full Cast Edition]", "the hobbit", 0},
+		{"Disc Suffix", "The Hobbit - Disc 1", "the hobbit", 1},
+		{"CD Suffix", "The Hobbit CD2", "the hobbit", 2},
+		{"Part Of", "The Hobbit (Part 3 of 4)", "the hobbit", 3},
+		{"Disc And Edition", "The Hobbit (Unabridged) - Disc 02", "the hobbit", 2},
+		{"Accents", "Les Misérables", "les miserables", 0},
+		{"Compatibility Characters", "ﬁnal Fantasy", "final fantasy", 0},
+		{"Case Folding", "STRAßE", "strasse", 0},
+		{"Punctuation", "Harry Potter: and the Philosopher's Stone!", "harry potter and the philosopher s stone", 0},
+		{"Number In Title", "Catch-22", "catch 22", 0},
+		{"Word Ending In Part", "Counterpart", "counterpart", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotDiscNum := normalizeTitle(tt.title)
+			if got != tt.want || gotDiscNum != tt.wantDiscNum {
+				t.Errorf("normalizeTitle() = %q, %d, want %q, %d", got, gotDiscNum, tt.want, tt.wantDiscNum)
+			}
+		})
+	}
+}
+
+func Test_titleSimilarity(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want float64
+	}{
+		{"Same", "the hobbit", "the hobbit", 1},
+		{"Typo", "the hobbit", "the hobbitt", 1 - 1.0/11},
+		{"Different", "dune", "emma", 0},
+		{"Series", "foundation 1", "foundation 2", 0},
+		{"Same Numbers", "catch 22", "catch 22 ", 1 - 1.0/9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := titleSimilarity(tt.a, tt.b); got != tt.want {
+				t.Errorf("titleSimilarity() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnsortedBookLibrary_mergeSimilarTitles(t *testing.T) {
+	chapters := []RelativeAudioBookChapter{
+		{bookTitle: "The Hobbit (Unabridged)", bookAuthor: "J.R.R. Tolkien", trackNum: 1, filePath: "hobbit/01.mp3"},
+		{bookTitle: "The Hobbit (Unabridged)", bookAuthor: "J.R.R. Tolkien", trackNum: 2, filePath: "hobbit/02.mp3"},
+		{bookTitle: "The Hobbit - Disc 2", bookAuthor: "J.R.R. Tolkien", trackNum: 1, filePath: "hobbit/03.mp3"},
+		{bookTitle: "the hobbit", bookAuthor: "J.R.R. Tolkien", discNum: 3, trackNum: 1, filePath: "hobbit/04.mp3"},
+		{bookTitle: "The Hobbitt", bookAuthor: "J.R.R. Tolkien", discNum: 4, trackNum: 1, filePath: "hobbit/05.mp3"},
+		{bookTitle: "The Hobbit", bookAuthor: "Someone Else", trackNum: 1, filePath: "notes/01.mp3"},
+		{bookTitle: "Foundation 1", bookAuthor: "Isaac Asimov", trackNum: 1, filePath: "foundation1/01.mp3"},
+		{bookTitle: "Foundation 2", bookAuthor: "Isaac Asimov", trackNum: 1, filePath: "foundation2/01.mp3"},
+	}
+
+	type args struct {
+		threshold float64
+	}
+	tests := []struct {
+		name string
+		args args
+		// Chapters in each book, by title
+		want       map[string]int
+		wantMerges []TitleMerge
+		// Disc numbers of the chapters in the merged Hobbit, by path
+		wantDiscNums map[string]int
+	}{
+		{
+			"Disabled",
+			args{0},
+			map[string]int{
+				"The Hobbit (Unabridged)": 2,
+				"The Hobbit - Disc 2":     1,
+				"the hobbit":              1,
+				"The Hobbitt":             1,
+				"The Hobbit":              1,
+				"Foundation 1":            1,
+				"Foundation 2":            1,
+			},
+			nil,
+			nil,
+		},
+		{
+			"Same Normalized Title",
+			args{1},
+			map[string]int{
+				"The Hobbit (Unabridged)": 4,
+				"The Hobbitt":             1,
+				"The Hobbit":              1,
+				"Foundation 1":            1,
+				"Foundation 2":            1,
+			},
+			[]TitleMerge{
+				{Into: "The Hobbit (Unabridged)", From: "The Hobbit - Disc 2", Similarity: 1, Chapters: 1},
+				{Into: "The Hobbit (Unabridged)", From: "the hobbit", Similarity: 1, Chapters: 1},
+			},
+			map[string]int{"hobbit/01.mp3": 0, "hobbit/02.mp3": 0, "hobbit/03.mp3": 2, "hobbit/04.mp3": 3},
+		},
+		{
+			"Similar Titles",
+			args{DefaultTitleSimilarity},
+			map[string]int{
+				"The Hobbit (Unabridged)": 5,
+				"The Hobbit":              1,
+				"Foundation 1":            1,
+				"Foundation 2":            1,
+			},
+			[]TitleMerge{
+				{Into: "The Hobbit (Unabridged)", From: "The Hobbit - Disc 2", Similarity: 1, Chapters: 1},
+				{Into: "The Hobbit (Unabridged)", From: "The Hobbitt", Similarity: 1 - 1.0/11, Chapters: 1},
+				{Into: "The Hobbit (Unabridged)", From: "the hobbit", Similarity: 1, Chapters: 1},
+			},
+			map[string]int{"hobbit/01.mp3": 0, "hobbit/02.mp3": 0, "hobbit/03.mp3": 2, "hobbit/04.mp3": 3, "hobbit/05.mp3": 4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var unsortedLibrary UnsortedBookLibrary
+			unsortedLibrary.Initialize()
+
+			for _, chapter := range chapters {
+				unsortedLibrary.AddChapter(chapter)
+			}
+
+			if got := unsortedLibrary.mergeSimilarTitles(tt.args.threshold); !reflect.DeepEqual(got, tt.wantMerges) {
+				t.Errorf("mergeSimilarTitles() = %v, want %v", got, tt.wantMerges)
+			}
+
+			got := make(map[string]int)
+			for _, book := range unsortedLibrary.books {
+				got[book.BookTitle] = len(book.Chapters)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Library has books %v, want %v", got, tt.want)
+			}
+
+			if tt.wantDiscNums == nil {
+				return
+			}
+
+			hobbit := unsortedLibrary.books[BookKey{Title: "The Hobbit (Unabridged)", Author: "J.R.R. Tolkien"}]
+			gotDiscNums := make(map[string]int)
+			for _, chapter := range hobbit.Chapters {
+				gotDiscNums[chapter.filePath] = chapter.discNum
+			}
+
+			if !reflect.DeepEqual(gotDiscNums, tt.wantDiscNums) {
+				paths := make([]string, 0, len(gotDiscNums))
+				for path := range gotDiscNums {
+					paths = append(paths, path)
+				}
+				sort.Strings(paths)
+
+				t.Errorf("Merged chapters %v have discs %v, want %v", paths, gotDiscNums, tt.wantDiscNums)
+			}
+		})
+	}
+}
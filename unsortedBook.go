@@ -4,6 +4,11 @@ package scanner
 
 import (
 	"errors"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	library "github.com/themooer1/audiobook-library"
 )
@@ -11,8 +16,11 @@ import (
 type BookTitle = string
 
 type UnsortedBook struct {
+	// The title the book is added to the library with
 	BookTitle BookTitle
-	Chapters  []RelativeAudioBookChapter
+	// The key its chapters were grouped by, see Grouper
+	Key      BookKey
+	Chapters []RelativeAudioBookChapter
 }
 
 var ErrEmptyBook = errors.New("book has no chapters")
@@ -27,9 +35,98 @@ func (u *UnsortedBook) AddChapter(chapter RelativeAudioBookChapter) {
 }
 
 func (u *UnsortedBook) IntoAudioBook(sort Sorter[RelativeAudioBookChapter]) (*library.AudioBook, []error) {
-	sortedRelChapters, errors := sort(u.Chapters)
-	if errors != nil {
-		return nil, errors
+	book, _, errors := u.intoAudioBook(sort)
+	return book, errors
+}
+
+// name returns the book's key with the title and author most of its chapters have, see
+// consensus.  The key's own are kept where no chapter has one, and books without a title
+// are named by their directory.
+func (u *UnsortedBook) name() BookKey {
+	name := u.Key
+
+	if title, _ := consensus(u.Chapters, chapterTitle); title != "" {
+		name.Title = title
+	}
+
+	if name.Title == "" && name.Directory != "" {
+		name.Title = filepath.Base(name.Directory)
+	}
+
+	if author, _ := consensus(u.Chapters, chapterAuthor); author != "" {
+		name.Author = author
+	}
+
+	return name
+}
+
+// sortedBook sorts the book, returning the errors that stopped it being sorted as ScanErrors.
+// Books that can't be sorted are left out of the library.
+func (u *UnsortedBook) sortedBook(sorter Sorter[RelativeAudioBookChapter]) (*library.AudioBook, string, []*ScanError) {
+	book, sortedBy, errs := u.intoAudioBook(sorter)
+	if len(errs) == 0 {
+		return book, sortedBy, nil
+	}
+
+	scanErrs := make([]*ScanError, len(errs))
+	for i, err := range errs {
+		scanErrs[i] = &ScanError{Book: u.BookTitle, Stage: StageSort, Err: err}
+	}
+
+	return nil, "", scanErrs
+}
+
+// Authors returns the authors of the chapters the book is named after, see SplitAuthors
+func (u *UnsortedBook) Authors() []Author {
+	author, _ := consensus(u.Chapters, chapterAuthor)
+
+	for i := range u.Chapters {
+		if chapterAuthor(&u.Chapters[i]) == author {
+			return u.Chapters[i].Authors()
+		}
+	}
+
+	return nil
+}
+
+// directoryName returns the directory the book was grouped by, relative to the root of its
+// chapters, so titles don't name roots or their urls.  See GroupByDirectory.
+func (u *UnsortedBook) directoryName() string {
+	dir := u.Key.Directory
+	if dir == "" || len(u.Chapters) == 0 || u.Chapters[0].root == "" {
+		return dir
+	}
+
+	root := chapterURL(u.Chapters[0].root, ".")
+	if !strings.HasPrefix(dir, root) {
+		return path.Base(filepath.ToSlash(dir))
+	}
+
+	name := strings.TrimLeft(dir[len(root):], `/\`)
+	if isURL(root) {
+		if unescaped, err := url.PathUnescape(name); err == nil {
+			name = unescaped
+		}
+	}
+
+	if name == "" {
+		return "."
+	}
+
+	return filepath.ToSlash(name)
+}
+
+// intoAudioBook also returns the name of the sorter that ordered the chapters, see Named
+func (u *UnsortedBook) intoAudioBook(sorter Sorter[RelativeAudioBookChapter]) (*library.AudioBook, string, []error) {
+	// Chapters arrive in whatever order the workers read them, so put them in path order first.
+	// Ties in the sorter then don't depend on timing.
+	sort.SliceStable(u.Chapters, func(i, j int) bool {
+		return u.Chapters[i].url() < u.Chapters[j].url()
+	})
+
+	sortedRelChapters, errors := sorter(u.Chapters)
+	if len(errors) > 0 {
+		return nil, "", errors
 	}
 
 	chapters := make([]library.AudioBookChapter, len(sortedRelChapters))
@@ -38,11 +135,16 @@ func (u *UnsortedBook) IntoAudioBook(sort Sorter[RelativeAudioBookChapter]) (*li
 	}
 
 	if len(chapters) == 0 {
-		return nil, []error{ErrEmptyBook}
+		return nil, "", []error{ErrEmptyBook}
 	}
 
-	bookTitle := u.Chapters[0].bookTitle
-	bookAuthor := u.Chapters[0].bookAuthor
+	name := u.name()
+
+	bookTitle := u.BookTitle
+	if bookTitle == "" {
+		bookTitle = name.Title
+	}
+	bookAuthor := name.Author
 	bookDescription := "Description not available"
 
 	return &library.AudioBook{
@@ -50,5 +152,5 @@ func (u *UnsortedBook) IntoAudioBook(sort Sorter[RelativeAudioBookChapter]) (*li
 		Author:      bookAuthor,
 		Description: bookDescription,
 		Chapters:    chapters,
-	}, nil
+	}, sortedRelChapters[0].sortedBy, nil
 }
@@ -1,43 +1,221 @@
 package scanner
 
-import library "github.com/themooer1/audiobook-library"
+import (
+	"sort"
+
+	library "github.com/themooer1/audiobook-library"
+)
 
 type UnsortedBookLibrary struct {
-	books map[BookTitle]UnsortedBook
+	grouper Grouper
+	books   map[BookKey]UnsortedBook
+	// Titles already given to other books, like ones streamed from other directories, if not
+	// nil.  Books are given other titles, and their titles are added to it.
+	takenTitles map[BookTitle]struct{}
 }
 
+// Initialize creates a library that groups chapters with GroupByTags
 func (u *UnsortedBookLibrary) Initialize() {
-	u.books = make(map[BookTitle]UnsortedBook)
+	u.InitializeGroupedBy(GroupByTags)
+}
+
+// InitializeGroupedBy creates a library that groups chapters with grouper, falling back
+// to GroupByAlbum for chapters it can't group
+func (u *UnsortedBookLibrary) InitializeGroupedBy(grouper Grouper) {
+	u.grouper = ComposeGroupers(grouper, GroupByAlbum)
+	u.books = make(map[BookKey]UnsortedBook)
+}
+
+// AddChapter adds the chapter to its book, and reports whether that created a new book
+func (u *UnsortedBookLibrary) AddChapter(chapter RelativeAudioBookChapter) bool {
+	_, added := u.addChapter(chapter)
+	return added
 }
 
-func (u *UnsortedBookLibrary) AddChapter(chapter RelativeAudioBookChapter) {
-	bookTitle := chapter.bookTitle
+// addChapter also returns the key of the chapter's book
+func (u *UnsortedBookLibrary) addChapter(chapter RelativeAudioBookChapter) (BookKey, bool) {
+	key, _ := u.grouper(&chapter)
 
 	var b UnsortedBook
 	var ok bool
-	if b, ok = u.books[bookTitle]; !ok {
-		b.Initialize(bookTitle)
+	if b, ok = u.books[key]; !ok {
+		b.Initialize(key.Title)
+		b.Key = key
 	}
 
 	b.AddChapter(chapter)
-	u.books[bookTitle] = b
+	u.books[key] = b
+
+	return key, !ok
+}
+
+// removeChapter removes the chapter read from filePath from the book with the key, and the
+// book once it has no chapters left
+func (u *UnsortedBookLibrary) removeChapter(key BookKey, filePath string) {
+	b, ok := u.books[key]
+	if !ok {
+		return
+	}
+
+	chapters := b.Chapters[:0]
+	for _, chapter := range b.Chapters {
+		if chapter.filePath != filePath {
+			chapters = append(chapters, chapter)
+		}
+	}
+
+	if len(chapters) == 0 {
+		delete(u.books, key)
+		return
+	}
+
+	b.Chapters = chapters
+	u.books[key] = b
+}
+
+// clone returns a copy of the library whose books can be changed without changing these
+func (u *UnsortedBookLibrary) clone() UnsortedBookLibrary {
+	clone := UnsortedBookLibrary{grouper: u.grouper, books: make(map[BookKey]UnsortedBook, len(u.books))}
+	for key, book := range u.books {
+		book.Chapters = append([]RelativeAudioBookChapter(nil), book.Chapters...)
+		clone.books[key] = book
+	}
+
+	return clone
+}
+
+// A book and its name, see UnsortedBook.name
+type namedBook struct {
+	key  BookKey
+	name BookKey
+}
+
+// namedBooks returns the books in order of name
+func (u *UnsortedBookLibrary) namedBooks() []namedBook {
+	books := make([]namedBook, 0, len(u.books))
+	for key, book := range u.books {
+		books = append(books, namedBook{key, book.name()})
+	}
+	sort.Slice(books, func(i, j int) bool {
+		if books[i].name != books[j].name {
+			return bookKeyLess(books[i].name, books[j].name)
+		}
+
+		return bookKeyLess(books[i].key, books[j].key)
+	})
+
+	return books
+}
+
+// titleCollisions calls onCollision with each run of books that share a title, in order of
+// title, and returns the collisions
+func titleCollisions(books []namedBook, onCollision func(books []namedBook)) []TitleCollision {
+	var collisions []TitleCollision
+
+	for i := 0; i < len(books); {
+		// Books with the same title are next to each other
+		j := i + 1
+		for j < len(books) && books[j].name.Title == books[i].name.Title {
+			j++
+		}
+
+		if j-i > 1 {
+			collision := TitleCollision{Title: books[i].name.Title}
+			for _, book := range books[i:j] {
+				collision.Authors = append(collision.Authors, book.name.Author)
+			}
+			collisions = append(collisions, collision)
+
+			onCollision(books[i:j])
+		}
+
+		i = j
+	}
+
+	return collisions
+}
+
+// titleCollisions returns the titles shared by several books, in order of title
+func (u *UnsortedBookLibrary) titleCollisions() []TitleCollision {
+	return titleCollisions(u.namedBooks(), func([]namedBook) {})
 }
 
 func (u *UnsortedBookLibrary) AddAllToAudioBookLibrary(library *library.AudioBookLibrary, sorter Sorter[RelativeAudioBookChapter]) []error {
+	return u.addAllToAudioBookLibrary(library, sorter, ignoreSortedBook)
+}
+
+// Called after sorting each book, with the sorted book and the name of its sorter,
+// or the errors that stopped it being sorted
+type bookSortedHandler func(unsortedBook *UnsortedBook, book *library.AudioBook, sortedBy string, errors []*ScanError)
+
+func ignoreSortedBook(*UnsortedBook, *library.AudioBook, string, []*ScanError) {}
+
+func (u *UnsortedBookLibrary) addAllToAudioBookLibrary(lib *library.AudioBookLibrary, sorter Sorter[RelativeAudioBookChapter], onBookSorted bookSortedHandler) []error {
+	return u.sortBooks(sorter, func(unsortedBook *UnsortedBook, book *library.AudioBook, sortedBy string, errors []*ScanError) {
+		onBookSorted(unsortedBook, book, sortedBy, errors)
+
+		// Add sorted book to library
+		if book != nil {
+			lib.Add(*book)
+		}
+	})
+}
+
+// titledBooks returns the books in order of name, and the titles they're added to the library
+// with.  Books whose title collides with another's are named with their author, see
+// TitleCollision, and ones whose title is already taken are numbered, see unusedTitle.
+func (u *UnsortedBookLibrary) titledBooks() ([]namedBook, map[BookKey]BookTitle) {
+	books := u.namedBooks()
+
+	titles := make(map[BookKey]BookTitle, len(books))
+	for _, book := range books {
+		titles[book.key] = book.name.Title
+	}
+
+	titleCollisions(books, func(collided []namedBook) {
+		names := make([]BookKey, len(collided))
+		for i, book := range collided {
+			names[i] = book.name
+			unsortedBook := u.books[book.key]
+			names[i].Directory = unsortedBook.directoryName()
+		}
+
+		for i, title := range disambiguatedTitles(names) {
+			titles[collided[i].key] = title
+		}
+	})
+
+	if u.takenTitles != nil {
+		for _, book := range books {
+			titles[book.key] = unusedTitle(titles[book.key], u.takenTitles)
+			u.takenTitles[titles[book.key]] = struct{}{}
+		}
+	}
+
+	return books, titles
+}
+
+// sortBooks sorts each book, passing it to onBookSorted in order of title, see titledBooks
+func (u *UnsortedBookLibrary) sortBooks(sorter Sorter[RelativeAudioBookChapter], onBookSorted bookSortedHandler) []error {
 	var errors []error
 
-	// Add books to library
-	for _, unsortedBook := range u.books {
-		// Sort each book
-		book, err := unsortedBook.IntoAudioBook(sorter)
+	books, titles := u.titledBooks()
+	defaultSortedBy := sorterName(sorter)
 
-		// exit if any book fails
-		if err != nil {
-			errors = append(errors, err...)
+	for _, book := range books {
+		unsortedBook := u.books[book.key]
+		unsortedBook.BookTitle = titles[book.key]
+
+		book, sortedBy, scanErrs := unsortedBook.sortedBook(sorter)
+		for _, err := range scanErrs {
+			errors = append(errors, err)
 		}
 
-		// Add sorted book to library
-		library.Add(*book)
+		if book != nil && sortedBy == "" {
+			sortedBy = defaultSortedBy
+		}
+
+		onBookSorted(&unsortedBook, book, sortedBy, scanErrs)
 	}
 
 	return errors
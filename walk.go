@@ -1,8 +1,10 @@
 package scanner
 
 import (
+	"context"
 	"io/fs"
 	"path/filepath"
+	"strings"
 
 	"os"
 )
@@ -10,25 +12,212 @@ import (
 type WalkFilter func(path string, d fs.DirEntry) (bool, error)
 type WalkErrorHandler func(path string, d fs.DirEntry, err error) error
 type WalkFileHandler func(path string, d fs.DirEntry)
+type WalkSkipHandler func(path string, d fs.DirEntry, reason SkipReason)
 
-func Walk(rootDir string, filter WalkFilter, fileHandler WalkFileHandler, errorHandler WalkErrorHandler) {
+// WalkOptions configures a walk.  The zero value walks with the defaults.
+type WalkOptions struct {
+	// Gitignore-style patterns, relative to the root of the walk, for paths to skip
+	// on top of the ones in IgnoreFileName files
+	IgnorePatterns []string
+	// Patterns for the names of junk files and directories to skip, matched with path.Match.
+	// If nil, DefaultJunkPatterns are used, so set an empty slice to keep everything.
+	JunkPatterns []string
+	// Called with each entry that's skipped as junk, ignored or a duplicate, if not nil.
+	// Skipped directories aren't read, so their contents aren't passed on.
+	OnSkipped WalkSkipHandler
+	// Whether to walk into directories that symlinks point to.  Each file and directory is
+	// only visited once, by the first path the walk reaches it through, which also stops
+	// symlink loops.  Filesystems without inodes, see fileInode, don't follow directory symlinks.
+	FollowSymlinks bool
+	// Number of directories listed at once.  If it's more than one, entries are passed to the
+	// handlers as soon as their directory is listed, rather than in lexical order like
+	// fs.WalkDir.  Handlers are never called concurrently either way.
+	Concurrency int
+	// Called once all of a directory's entries have been passed to the handlers, if not nil.
+	// Its subdirectories may not have been walked yet.
+	OnDirDone func(path string)
+	// Whether to walk into .zip archives like directories, as if the filesystem were wrapped
+	// in a ZipFS.  The archives are closed when the walk is done.
+	OpenZipArchives bool
+}
+
+// isUnder reports whether name is dir or inside it
+func isUnder(name string, dir string) bool {
+	return dir == "." || name == dir || strings.HasPrefix(name, dir+"/")
+}
+
+// walker holds the state of a walk
+type walker struct {
+	ctx          context.Context
+	fsys         fs.FS
+	options      WalkOptions
+	filter       WalkFilter
+	fileHandler  WalkFileHandler
+	errorHandler WalkErrorHandler
+	ignore       ignoreMatcher
+	visited      inodeSet
+	// Walks the directory target, which a followed symlink at path points to
+	walkLink func(path string, target fs.DirEntry) error
+}
+
+func (w *walker) Initialize(ctx context.Context, fsys fs.FS, options WalkOptions, filter WalkFilter, fileHandler WalkFileHandler, errorHandler WalkErrorHandler) {
+	w.ctx = ctx
+	w.fsys = fsys
+	w.options = options
+	w.filter = filter
+	w.fileHandler = fileHandler
+	w.errorHandler = errorHandler
+	w.ignore.Initialize(fsys, options.IgnorePatterns, options.JunkPatterns)
+	w.visited.Initialize()
+}
 
-	walkDirFunc := func(path string, d fs.DirEntry, err error) error {
-		if err == nil {
+func (w *walker) skip(path string, d fs.DirEntry, reason SkipReason) error {
+	if w.options.OnSkipped != nil {
+		w.options.OnSkipped(path, d, reason)
+	}
+
+	if d.IsDir() {
+		return fs.SkipDir
+	}
+
+	return nil
+}
+
+func (w *walker) dirDone(path string) {
+	if w.options.OnDirDone != nil {
+		w.options.OnDirDone(path)
+	}
+}
 
-			includeFile, err := filter(path, d)
-			if err == nil && includeFile {
-				fileHandler(filepath.Join(rootDir, path), d)
-				// fileHandler(path, d)
+// visit handles an entry of the walk, with the semantics of an fs.WalkDirFunc
+func (w *walker) visit(path string, d fs.DirEntry, err error) error {
+	if w.ctx.Err() != nil {
+		// Stops the walk
+		return w.ctx.Err()
+	}
+
+	if err == nil {
+		skipReason, ignoreErr := w.ignore.skipReason(path, d.IsDir())
+		if ignoreErr != nil {
+			// An unreadable ignore file doesn't stop the walk
+			if err := w.errorHandler(path, d, ignoreErr); err != nil {
+				return err
 			}
-		} else {
-			err = errorHandler(path, d, err)
 		}
 
-		return err
+		if skipReason != "" {
+			return w.skip(path, d, skipReason)
+		}
+	}
+
+	if err == nil && w.options.FollowSymlinks {
+		if d.Type()&fs.ModeSymlink != 0 {
+			info, statErr := fs.Stat(w.fsys, path)
+			if statErr != nil {
+				return w.errorHandler(path, d, statErr)
+			}
+
+			// Directories are walked from the link, and their first visit checks they haven't been
+			// walked already.  Without inodes loops can't be detected, so they aren't followed.
+			if info.IsDir() && hasInode(info) {
+				return w.walkLink(path, fs.FileInfoToDirEntry(info))
+			}
+
+			d = fs.FileInfoToDirEntry(info)
+		}
+
+		if !w.visited.add(d) {
+			return w.skip(path, d, SkipDuplicate)
+		}
+	}
+
+	if err == nil {
+		var includeFile bool
+		includeFile, err = w.filter(path, d)
+		if err == nil && includeFile {
+			w.fileHandler(path, d)
+		}
 	}
 
-	filesystem := os.DirFS(rootDir)
-	fs.WalkDir(filesystem, ".", walkDirFunc)
+	// Errors from the filter are handled like errors reading the tree
+	if err != nil {
+		err = w.errorHandler(path, d, err)
+	}
+
+	return err
+}
+
+// walkSequential walks the tree with fs.WalkDir, in lexical order
+func (w *walker) walkSequential() {
+	// The directories containing the entry the walk is at, from the root down.  WalkDir walks
+	// depth first, so once it visits a path outside a directory it's done with the directory.
+	var walking []string
+	leaveDirs := func(path string) {
+		for len(walking) > 0 && !isUnder(path, walking[len(walking)-1]) {
+			w.dirDone(walking[len(walking)-1])
+			walking = walking[:len(walking)-1]
+		}
+	}
+
+	var walkDirFunc fs.WalkDirFunc
+	walkDirFunc = func(path string, d fs.DirEntry, err error) error {
+		leaveDirs(path)
+
+		result := w.visit(path, d, err)
+		if result == nil && err == nil && d.IsDir() {
+			walking = append(walking, path)
+		}
+
+		return result
+	}
+
+	w.walkLink = func(path string, target fs.DirEntry) error {
+		return fs.WalkDir(w.fsys, path, walkDirFunc)
+	}
+
+	fs.WalkDir(w.fsys, ".", walkDirFunc)
+
+	for i := len(walking) - 1; i >= 0; i-- {
+		w.dirDone(walking[i])
+	}
+}
+
+// walkFS walks fsys until it's done or ctx is cancelled
+func walkFS(ctx context.Context, fsys fs.FS, options WalkOptions, filter WalkFilter, fileHandler WalkFileHandler, errorHandler WalkErrorHandler) {
+	if options.OpenZipArchives {
+		var closeArchives func()
+		fsys, closeArchives = openZipArchives(fsys)
+		defer closeArchives()
+	}
+
+	var w walker
+	w.Initialize(ctx, fsys, options, filter, fileHandler, errorHandler)
+
+	if options.Concurrency > 1 {
+		w.walkParallel(options.Concurrency)
+	} else {
+		w.walkSequential()
+	}
+}
+
+// WalkContext walks fsys like WalkFS with the given options, until it's done or ctx is cancelled
+func WalkContext(ctx context.Context, fsys fs.FS, filter WalkFilter, fileHandler WalkFileHandler, errorHandler WalkErrorHandler, options WalkOptions) {
+	walkFS(ctx, fsys, options, filter, fileHandler, errorHandler)
+}
+
+// WalkFS walks fsys from its root, calling fileHandler for every entry the filter accepts.
+// Paths passed to the handlers are relative to the root of fsys.  Junk, see
+// DefaultJunkPatterns, and paths excluded by IgnoreFileName files are skipped.
+func WalkFS(fsys fs.FS, filter WalkFilter, fileHandler WalkFileHandler, errorHandler WalkErrorHandler) {
+	WalkContext(context.Background(), fsys, filter, fileHandler, errorHandler, WalkOptions{})
+}
+
+// Walk walks the directory tree at rootDir.  Paths passed to the fileHandler are joined
+// with rootDir, while the filter and errorHandler see paths relative to rootDir.
+func Walk(rootDir string, filter WalkFilter, fileHandler WalkFileHandler, errorHandler WalkErrorHandler) {
+	joinedFileHandler := func(path string, d fs.DirEntry) {
+		fileHandler(filepath.Join(rootDir, path), d)
+	}
 
+	WalkFS(os.DirFS(rootDir), filter, joinedFileHandler, errorHandler)
 }
@@ -0,0 +1,155 @@
+package scanner
+
+import (
+	"io/fs"
+	"path"
+	"sync"
+)
+
+// A directory waiting to be listed by walkParallel
+type dirListing struct {
+	dir string
+	d   fs.DirEntry
+}
+
+// walkParallel walks the tree with concurrency workers, each listing one directory at a time
+// from a queue.  Entries are visited as soon as their directory is listed, but never
+// concurrently, and with the same semantics as fs.WalkDir otherwise.
+func (w *walker) walkParallel(concurrency int) {
+	var mutex sync.Mutex
+
+	// Directories waiting to be listed, and the number queued or being listed, which only
+	// reaches 0 once the walk is done
+	var queueMutex sync.Mutex
+	queueChanged := sync.NewCond(&queueMutex)
+	var queue []dirListing
+	pending := 0
+
+	enqueue := func(dir string, d fs.DirEntry) {
+		queueMutex.Lock()
+		queue = append(queue, dirListing{dir, d})
+		pending++
+		queueMutex.Unlock()
+		queueChanged.Signal()
+	}
+
+	// Set once an entry's visit returns an error other than fs.SkipDir, which stops the walk
+	stopped := false
+
+	// visit visits the entry, reporting whether its directory should be listed.  The mutex must be held.
+	visit := func(name string, d fs.DirEntry, err error) (bool, error) {
+		if stopped {
+			return false, nil
+		}
+
+		result := w.visit(name, d, err)
+		if result != nil && result != fs.SkipDir {
+			stopped = true
+		}
+
+		return result == nil && err == nil && d.IsDir(), result
+	}
+
+	listDir := func(dir string, d fs.DirEntry) {
+		var entries []fs.DirEntry
+		var err error
+		if w.ctx.Err() == nil {
+			entries, err = fs.ReadDir(w.fsys, dir)
+
+			// Read the directory's ignore file now too, rather than while visits are held up
+			// waiting for it.  Its errors are reported when its entries are visited.
+			w.ignore.loadIgnoreFile(dir)
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		// Like fs.WalkDir, the directory is visited again with the error, and the entries
+		// that could be read are still walked unless that returns an error
+		if err != nil {
+			if _, result := visit(dir, d, err); result != nil {
+				w.dirDone(dir)
+				return
+			}
+		}
+
+		for _, entry := range entries {
+			name := path.Join(dir, entry.Name())
+
+			list, result := visit(name, entry, nil)
+			if list {
+				enqueue(name, entry)
+			}
+
+			// Skipping a file skips the rest of its directory
+			if result == fs.SkipDir && !entry.IsDir() || stopped {
+				break
+			}
+		}
+
+		w.dirDone(dir)
+	}
+
+	// Symlinked directories are listed like any other, after their first visit
+	w.walkLink = func(name string, target fs.DirEntry) error {
+		result := w.visit(name, target, nil)
+		if result == nil && target.IsDir() {
+			enqueue(name, target)
+		}
+
+		if result == fs.SkipDir {
+			return nil
+		}
+
+		return result
+	}
+
+	info, err := fs.Stat(w.fsys, ".")
+	var root fs.DirEntry
+	if err == nil {
+		root = fs.FileInfoToDirEntry(info)
+	}
+
+	mutex.Lock()
+	if list, _ := visit(".", root, err); list {
+		enqueue(".", root)
+	}
+	mutex.Unlock()
+
+	// Each worker lists directories until none are queued or being listed
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			queueMutex.Lock()
+			defer queueMutex.Unlock()
+
+			for {
+				for len(queue) == 0 && pending > 0 {
+					queueChanged.Wait()
+				}
+
+				if pending == 0 {
+					return
+				}
+
+				listing := queue[0]
+				queue = queue[1:]
+
+				queueMutex.Unlock()
+				listDir(listing.dir, listing.d)
+				queueMutex.Lock()
+
+				// Wake the other workers to finish once the last listing is done
+				pending--
+				if pending == 0 {
+					queueChanged.Broadcast()
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
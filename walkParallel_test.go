@@ -0,0 +1,179 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"reflect"
+	"runtime"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+)
+
+func TestWalkContext_Concurrency(t *testing.T) {
+	files := fstest.MapFS{}
+	for book := 0; book < 20; book++ {
+		for chapter := 0; chapter < 3; chapter++ {
+			files[fmt.Sprintf("author%d/book%d/chapter%d.mp3", book%4, book, chapter)] = &fstest.MapFile{}
+		}
+	}
+	files["samples/sample.mp3"] = &fstest.MapFile{}
+	files["broken/chapter.mp3"] = &fstest.MapFile{}
+	fsys := unreadableDirFS{files, "broken"}
+
+	type args struct {
+		concurrency int
+	}
+	tests := []struct {
+		name string
+		args args
+	}{
+		{"Sequential", args{1}},
+		{"Parallel", args{8}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var found, errors, skipped, dirsDone []string
+
+			// Detects handlers being called concurrently
+			var active int32
+			enter := func() func() {
+				if atomic.AddInt32(&active, 1) != 1 {
+					t.Error("Handlers were called concurrently")
+				}
+
+				return func() { atomic.AddInt32(&active, -1) }
+			}
+
+			filter := func(path string, d fs.DirEntry) (bool, error) {
+				defer enter()()
+				return !d.IsDir(), nil
+			}
+			fileHandler := func(path string, d fs.DirEntry) {
+				defer enter()()
+				found = append(found, path)
+			}
+			errorHandler := func(path string, d fs.DirEntry, err error) error {
+				defer enter()()
+				errors = append(errors, path)
+				return nil
+			}
+
+			options := WalkOptions{
+				IgnorePatterns: []string{"samples/"},
+				Concurrency:    tt.args.concurrency,
+				OnSkipped: func(path string, d fs.DirEntry, reason SkipReason) {
+					skipped = append(skipped, path)
+				},
+				OnDirDone: func(path string) {
+					dirsDone = append(dirsDone, path)
+				},
+			}
+
+			WalkContext(context.Background(), fsys, filter, fileHandler, errorHandler, options)
+
+			var wantFound []string
+			wantDirsDone := []string{".", "broken"}
+			for name := range files {
+				if dir := path.Dir(name); dir != "samples" && dir != "broken" {
+					wantFound = append(wantFound, name)
+				}
+			}
+			for author := 0; author < 4; author++ {
+				wantDirsDone = append(wantDirsDone, fmt.Sprintf("author%d", author))
+			}
+			for book := 0; book < 20; book++ {
+				wantDirsDone = append(wantDirsDone, fmt.Sprintf("author%d/book%d", book%4, book))
+			}
+
+			for _, result := range [][]string{found, dirsDone, wantFound, wantDirsDone} {
+				sort.Strings(result)
+			}
+
+			if !reflect.DeepEqual(found, wantFound) {
+				t.Errorf("Found %v, want %v", found, wantFound)
+			}
+
+			if !reflect.DeepEqual(dirsDone, wantDirsDone) {
+				t.Errorf("Finished directories %v, want %v", dirsDone, wantDirsDone)
+			}
+
+			if !reflect.DeepEqual(errors, []string{"broken"}) {
+				t.Errorf("Errors for %v, want broken", errors)
+			}
+
+			if !reflect.DeepEqual(skipped, []string{"samples"}) {
+				t.Errorf("Skipped %v, want samples", skipped)
+			}
+		})
+	}
+}
+
+func TestWalkContext_ConcurrencyCancelled(t *testing.T) {
+	files := fstest.MapFS{}
+	for book := 0; book < 50; book++ {
+		files[fmt.Sprintf("book%d/chapter.mp3", book)] = &fstest.MapFile{}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	found := 0
+	fileHandler := func(path string, d fs.DirEntry) {
+		found++
+		if found == 5 {
+			cancel()
+		}
+	}
+
+	filter := func(path string, d fs.DirEntry) (bool, error) {
+		return !d.IsDir(), nil
+	}
+
+	WalkContext(ctx, files, filter, fileHandler, printErrorHandler, WalkOptions{Concurrency: 4})
+
+	if found != 5 {
+		t.Errorf("Found %d files after cancelling, want 5", found)
+	}
+}
+
+// Records the most goroutines running while a directory was listed
+type goroutineCountingFS struct {
+	fstest.MapFS
+	most int32
+}
+
+func (g *goroutineCountingFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	count := int32(runtime.NumGoroutine())
+	for most := atomic.LoadInt32(&g.most); count > most; most = atomic.LoadInt32(&g.most) {
+		if atomic.CompareAndSwapInt32(&g.most, most, count) {
+			break
+		}
+	}
+
+	return g.MapFS.ReadDir(name)
+}
+
+func TestWalkContext_ConcurrencyBounded(t *testing.T) {
+	files := fstest.MapFS{}
+	for book := 0; book < 200; book++ {
+		files[fmt.Sprintf("book%d/chapter.mp3", book)] = &fstest.MapFile{}
+	}
+	fsys := &goroutineCountingFS{MapFS: files}
+
+	filter := func(path string, d fs.DirEntry) (bool, error) {
+		return !d.IsDir(), nil
+	}
+
+	const concurrency = 4
+	before := runtime.NumGoroutine()
+	WalkContext(context.Background(), fsys, filter, func(string, fs.DirEntry) {}, printErrorHandler, WalkOptions{Concurrency: concurrency})
+
+	// Only the workers run alongside the test, however many directories are waiting
+	if started := int(fsys.most) - before; started > concurrency {
+		t.Errorf("Walk ran %d goroutines listing directories, want at most %d", started, concurrency)
+	}
+}
@@ -1,11 +1,16 @@
 package scanner
 
 import (
+	"context"
 	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
+	"testing/fstest"
+
+	library "github.com/themooer1/audiobook-library"
 )
 
 type FilePath = string
@@ -213,3 +218,96 @@ func TestWalk(t *testing.T) {
 		})
 	}
 }
+
+func TestWalkFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"rootfile":                        {},
+		"folder/folderfile":               {},
+		"folder/subfolder/subfolderfile1": {},
+	}
+
+	result := walkTestResult{}
+	result.Initialize("")
+	result.addFile("rootfile")
+	result.addFile("folder/folderfile")
+	result.addFile("folder/subfolder/subfolderfile1")
+
+	fileFilter := func(path string, dirEntry fs.DirEntry) (bool, error) {
+		return !dirEntry.IsDir(), nil
+	}
+
+	WalkFS(fsys, fileFilter, result.getFileHandler(t), result.getErrorHandler(t))
+
+	if !result.isSuccessful() {
+		t.Errorf("Files not walked: %v", result.files)
+	}
+}
+
+func TestWalkContext_FollowSymlinks(t *testing.T) {
+	root := t.TempDir()
+	copyTestFile(t, "testdata/audiobooks/frankenstein/frankenstein_00_shelley_64kb.mp3", filepath.Join(root, "users/alice/book/00.mp3"))
+	copyTestFile(t, "testdata/audiobooks/frankenstein/frankenstein_01_shelley_64kb.mp3", filepath.Join(root, "shared/book/01.mp3"))
+
+	links := map[string]string{
+		// Another user's folder
+		"users/bob": "../shared",
+		// A folder that's also reachable directly
+		"library/alice": "../users/alice",
+		// A loop
+		"users/alice/book/loop": "..",
+		// A file that's also reachable directly
+		"users/alice/book/00-link.mp3": "00.mp3",
+	}
+	os.MkdirAll(filepath.Join(root, "library"), 0755)
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Skipf("Can't create symlinks: %v", err)
+		}
+	}
+
+	result := walkTestResult{}
+	result.Initialize("")
+	// Walked in lexical order, so the first path to each file wins
+	result.addFile("library/alice/book/00-link.mp3")
+	result.addFile("shared/book/01.mp3")
+
+	var skipped []string
+	options := WalkOptions{
+		FollowSymlinks: true,
+		OnSkipped: func(path string, d fs.DirEntry, reason SkipReason) {
+			if reason != SkipDuplicate {
+				t.Errorf("Unexpected skip reason %v for %v", reason, path)
+			}
+
+			skipped = append(skipped, path)
+		},
+	}
+
+	fileFilter := func(path string, dirEntry fs.DirEntry) (bool, error) {
+		return !dirEntry.IsDir(), nil
+	}
+
+	WalkContext(context.Background(), os.DirFS(root), fileFilter, result.getFileHandler(t), result.getErrorHandler(t), options)
+
+	if !result.isSuccessful() {
+		t.Errorf("Files not walked: %v", result.files)
+	}
+
+	wantSkipped := []string{"library/alice/book/00.mp3", "library/alice/book/loop", "users/alice", "users/bob"}
+	if !reflect.DeepEqual(skipped, wantSkipped) {
+		t.Errorf("Skipped %v, want %v", skipped, wantSkipped)
+	}
+
+	// Each file is read into exactly one chapter
+	lib := library.AudioBookLibrary{}
+	lib.Initialize()
+
+	errors := ScanContext(context.Background(), os.DirFS(root), &lib, SortByDiscNumber[RelativeAudioBookChapter], ScanOptions{FollowSymlinks: true})
+	if len(errors) > 0 {
+		t.Errorf("ScanContext() returned errors: %v", errors)
+	}
+
+	if got := len(lib.Get("Frankenstein").Chapters); got != 2 {
+		t.Errorf("Expected 2 chapters, but got %d", got)
+	}
+}
@@ -0,0 +1,495 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	library "github.com/themooer1/audiobook-library"
+)
+
+// How long the tree must be quiet before changes are rescanned, if WatchOptions.Debounce is zero
+const DefaultWatchDebounce = 2 * time.Second
+
+type BookEventType string
+
+const (
+	BookAdded   BookEventType = "added"
+	BookUpdated BookEventType = "updated"
+	BookRemoved BookEventType = "removed"
+)
+
+// BookEvent describes a book that changed in a watched tree.  Removed books hold the
+// last version of the book.
+type BookEvent struct {
+	Type BookEventType
+	Book library.AudioBook
+}
+
+// Apply updates the library with the change described by the event
+func (e *BookEvent) Apply(lib *library.AudioBookLibrary) {
+	if e.Type == BookRemoved {
+		delete(lib.AudioBooksByName, e.Book.Title)
+	} else {
+		lib.Add(e.Book)
+	}
+}
+
+// WatchOptions configures Watch.  The zero value watches with the defaults.
+type WatchOptions struct {
+	// Number of goroutines reading tags.  If zero, DefaultWorkers picks it from the filesystem.
+	Workers int
+	// How long the tree must be quiet before changes are rescanned, so files
+	// that are still being copied aren't read.  If zero, DefaultWatchDebounce is used.
+	Debounce time.Duration
+	// Called with each error as it happens, if not nil
+	OnError ScanErrorHandler
+	// Gitignore-style patterns, relative to rootDir, for paths to skip
+	// on top of the ones in IgnoreFileName files
+	IgnorePatterns []string
+	// Patterns for the names of junk files and directories to skip, matched with path.Match.
+	// If nil, DefaultJunkPatterns are used, so set an empty slice to keep everything.
+	JunkPatterns []string
+	// Groups chapters into books.  If nil, GroupByTags is used.
+	Grouper Grouper
+	// Merges books with similar titles, see ScanOptions.MergeSimilarTitles
+	MergeSimilarTitles float64
+	// Separators between the authors in artist tags, see ScanOptions.AuthorSeparators
+	AuthorSeparators []string
+}
+
+type watcher struct {
+	ctx     context.Context
+	rootDir string
+	fsys    fs.FS
+	sorter  Sorter[RelativeAudioBookChapter]
+	options WatchOptions
+	notify  *fsnotify.Watcher
+	events  chan<- BookEvent
+	ignore  ignoreMatcher
+	// Splits artist tags into authors, see WatchOptions.AuthorSeparators
+	authorSeparators *regexp.Regexp
+
+	// Chapters and the versions of the files they were read from, by path relative to rootDir
+	chapters   map[string]RelativeAudioBookChapter
+	identities map[string]fileIdentity
+	// Paths of the chapters read or dropped since the books were last published
+	changed map[string]struct{}
+	// The chapters grouped into books, and the key of each chapter's book by its path
+	grouped UnsortedBookLibrary
+	keys    map[string]BookKey
+	// The books as they were last sorted, by key
+	sorted map[BookKey]watchedBook
+	// The books last sent in events, by title
+	books map[string]library.AudioBook
+}
+
+// A book as it was last sorted, so it's only sorted again once its title or chapters change
+type watchedBook struct {
+	title BookTitle
+	// Paths of the book's chapters, in order
+	paths []string
+	// The sorted book, or nil if it couldn't be sorted
+	book *library.AudioBook
+}
+
+// Watch scans rootDir, then follows it with filesystem notifications, rescanning the
+// directories that changed once they've been quiet for a while.  Every book found by the
+// first scan is sent as a BookAdded event, followed by events for each book added, updated
+// or removed afterwards.  The channel is closed once ctx is cancelled.
+func Watch(ctx context.Context, rootDir string, sorter Sorter[RelativeAudioBookChapter], options WatchOptions) (<-chan BookEvent, error) {
+	notify, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Debounce <= 0 {
+		options.Debounce = DefaultWatchDebounce
+	}
+
+	if options.Grouper == nil {
+		options.Grouper = GroupByTags
+	}
+
+	fsys := os.DirFS(rootDir)
+	if options.Workers <= 0 {
+		options.Workers = DefaultWorkers(fsys)
+	}
+
+	events := make(chan BookEvent, 100)
+	w := watcher{
+		ctx:        ctx,
+		rootDir:    rootDir,
+		fsys:       fsys,
+		sorter:     sorter,
+		options:    options,
+		notify:     notify,
+		events:     events,
+		chapters:   make(map[string]RelativeAudioBookChapter),
+		identities: make(map[string]fileIdentity),
+		changed:    make(map[string]struct{}),
+		keys:       make(map[string]BookKey),
+		sorted:     make(map[BookKey]watchedBook),
+		books:      make(map[string]library.AudioBook),
+
+		authorSeparators: authorSeparatorPattern(options.AuthorSeparators),
+	}
+	w.grouped.InitializeGroupedBy(options.Grouper)
+	w.ignore.Initialize(fsys, options.IgnorePatterns, options.JunkPatterns)
+
+	go func() {
+		defer close(events)
+		defer notify.Close()
+
+		w.rescan(".", true)
+		w.publish()
+		w.run()
+	}()
+
+	return events, nil
+}
+
+func (w *watcher) reportError(err *ScanError) {
+	if w.options.OnError != nil {
+		w.options.OnError(err)
+	}
+}
+
+// run collects filesystem events until ctx is cancelled, rescanning once they stop for a while
+func (w *watcher) run() {
+	pending := make(map[string]struct{})
+
+	debounce := time.NewTimer(w.options.Debounce)
+	debounce.Stop()
+
+	for {
+		select {
+		case <-w.ctx.Done():
+			debounce.Stop()
+			return
+		case event, ok := <-w.notify.Events:
+			if !ok {
+				return
+			}
+
+			relPath, err := filepath.Rel(w.rootDir, event.Name)
+			if err != nil {
+				continue
+			}
+			pending[filepath.ToSlash(relPath)] = struct{}{}
+
+			// Every event pushes the rescan back, so bursts are handled together
+			resetTimer(debounce, w.options.Debounce)
+		case err, ok := <-w.notify.Errors:
+			if !ok {
+				return
+			}
+
+			w.reportError(&ScanError{Path: w.rootDir, Stage: StageWalk, Err: err})
+		case <-debounce.C:
+			w.update(pending)
+			pending = make(map[string]struct{})
+		}
+	}
+}
+
+// resetTimer restarts the timer, first draining its channel if it fired without being read
+func resetTimer(timer *time.Timer, d time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+
+	timer.Reset(d)
+}
+
+// update rescans the paths that changed and publishes the books that changed as a result
+func (w *watcher) update(changedPaths map[string]struct{}) {
+	// Changed ignore files are read again, and their directories rescanned in case their files are no longer ignored
+	for changedPath := range changedPaths {
+		if path.Base(changedPath) == IgnoreFileName {
+			w.ignore.Initialize(w.fsys, w.options.IgnorePatterns, w.options.JunkPatterns)
+			changedPaths[path.Dir(changedPath)] = struct{}{}
+		}
+	}
+
+	for changedPath := range changedPaths {
+		info, err := fs.Stat(w.fsys, changedPath)
+
+		switch {
+		case path.Base(changedPath) == IgnoreFileName:
+		case errors.Is(err, fs.ErrNotExist):
+			w.forget(changedPath)
+		case err != nil:
+			w.reportError(&ScanError{Path: w.chapterPath(changedPath), Stage: StageWalk, Err: err})
+		case info.IsDir():
+			// New directories may have been filled before they were watched, so read all of them
+			w.rescan(changedPath, true)
+		default:
+			w.rescan(path.Dir(changedPath), false)
+		}
+	}
+
+	w.publish()
+}
+
+// forget drops the chapters at or under the removed path
+func (w *watcher) forget(removedPath string) {
+	for chapterPath := range w.chapters {
+		if isUnder(chapterPath, removedPath) {
+			w.drop(chapterPath)
+		}
+	}
+}
+
+// drop forgets the chapter read from chapterPath
+func (w *watcher) drop(chapterPath string) {
+	delete(w.chapters, chapterPath)
+	delete(w.identities, chapterPath)
+	w.changed[chapterPath] = struct{}{}
+}
+
+// rescan reads the audio files in dir that changed since they were last read, and forgets
+// the ones that are gone.  If recursive, its subdirectories are rescanned and watched too.
+func (w *watcher) rescan(dir string, recursive bool) {
+	// Paths in a skipped directory are treated as gone
+	skipped, err := w.ignore.skippedPath(dir, true)
+	if err != nil {
+		w.reportError(&ScanError{Path: w.chapterPath(dir), Stage: StageWalk, Err: err})
+	}
+
+	if skipped {
+		w.forget(dir)
+		return
+	}
+
+	found := make(map[string]struct{})
+	changed := make(map[string]fileIdentity)
+
+	walkDirFunc := func(name string, d fs.DirEntry, err error) error {
+		if w.ctx.Err() != nil {
+			return w.ctx.Err()
+		}
+
+		filePath := path.Join(dir, name)
+
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				w.reportError(&ScanError{Path: w.chapterPath(filePath), Stage: StageWalk, Err: err})
+			}
+
+			return nil
+		}
+
+		if name != "." {
+			skipReason, err := w.ignore.skipReason(filePath, d.IsDir())
+			if err != nil {
+				w.reportError(&ScanError{Path: w.chapterPath(filePath), Stage: StageWalk, Err: err})
+			}
+
+			if skipReason != "" && d.IsDir() {
+				return fs.SkipDir
+			} else if skipReason != "" {
+				return nil
+			}
+		}
+
+		if d.IsDir() {
+			if name != "." && !recursive {
+				return fs.SkipDir
+			}
+
+			if err := w.notify.Add(w.chapterPath(filePath)); err != nil {
+				w.reportError(&ScanError{Path: w.chapterPath(filePath), Stage: StageWalk, Err: err})
+			}
+
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil || !isSupportedAudioFile(info) {
+			return nil
+		}
+
+		found[filePath] = struct{}{}
+
+		identity := identifyFile(info)
+		if _, ok := w.chapters[filePath]; !ok || w.identities[filePath] != identity {
+			changed[filePath] = identity
+		}
+
+		return nil
+	}
+
+	if subFS, err := fs.Sub(w.fsys, dir); err == nil {
+		fs.WalkDir(subFS, ".", walkDirFunc)
+	}
+
+	// Forget files that are gone
+	for chapterPath := range w.chapters {
+		inDir := path.Dir(chapterPath) == dir || (recursive && isUnder(chapterPath, dir))
+		if _, ok := found[chapterPath]; inDir && !ok {
+			w.drop(chapterPath)
+		}
+	}
+
+	w.read(changed)
+}
+
+func (w *watcher) chapterPath(name string) string {
+	return filepath.Join(w.rootDir, filepath.FromSlash(name))
+}
+
+// read reads the chapters of the given files with the watcher's workers
+func (w *watcher) read(files map[string]fileIdentity) {
+	filesToScan := make(chan string, len(files))
+	for name := range files {
+		filesToScan <- name
+	}
+	close(filesToScan)
+
+	chapters := make(chan RelativeAudioBookChapter)
+
+	readChapter := func(name string) (RelativeAudioBookChapter, error) {
+		chapter, err := fromFS(w.fsys, name, w.rootDir, name)
+		chapter.splitAuthors(w.authorSeparators)
+
+		return chapter, err
+	}
+
+	// Files that can't be read, like ones still being copied, are dropped until they change again
+	failed := make(chan string, len(files))
+	onError := func(name string, err error) {
+		failed <- name
+
+		if scanErr, ok := err.(*ScanError); ok {
+			w.reportError(scanErr)
+		}
+	}
+
+	go startFileScanners(w.ctx, w.options.Workers, readChapter, filesToScan, chapters, onError)
+
+	for chapter := range chapters {
+		name := chapter.filePath
+		w.chapters[name] = chapter
+		w.identities[name] = files[name]
+		w.changed[name] = struct{}{}
+	}
+
+	close(failed)
+	for name := range failed {
+		w.drop(name)
+	}
+}
+
+// publish groups the chapters that changed and sorts the books they're in, sending an event
+// for every book that changed.  Other books are left as they were last sorted.
+func (w *watcher) publish() {
+	for chapterPath := range w.changed {
+		if key, ok := w.keys[chapterPath]; ok {
+			w.grouped.removeChapter(key, chapterPath)
+			delete(w.keys, chapterPath)
+		}
+
+		if chapter, ok := w.chapters[chapterPath]; ok {
+			w.keys[chapterPath], _ = w.grouped.addChapter(chapter)
+		}
+	}
+
+	unsortedLibrary := w.grouped
+	if w.options.MergeSimilarTitles > 0 {
+		// Merging changes the books, so it's done on a copy
+		unsortedLibrary = w.grouped.clone()
+		unsortedLibrary.mergeSimilarTitles(w.options.MergeSimilarTitles)
+	}
+
+	namedBooks, titles := unsortedLibrary.titledBooks()
+
+	sorted := make(map[BookKey]watchedBook, len(namedBooks))
+	books := make(map[string]library.AudioBook, len(namedBooks))
+	var events []BookEvent
+	for _, namedBook := range namedBooks {
+		unsortedBook := unsortedLibrary.books[namedBook.key]
+		unsortedBook.BookTitle = titles[namedBook.key]
+		paths := chapterPaths(unsortedBook.Chapters)
+
+		watched, ok := w.sorted[namedBook.key]
+		if !ok || watched.title != unsortedBook.BookTitle || w.chaptersChanged(watched.paths, paths) {
+			book, _, bookErrors := unsortedBook.sortedBook(w.sorter)
+			for _, err := range bookErrors {
+				w.reportError(err)
+			}
+
+			watched = watchedBook{title: unsortedBook.BookTitle, paths: paths, book: book}
+
+			if previous, ok := w.books[unsortedBook.BookTitle]; book != nil && !ok {
+				events = append(events, BookEvent{Type: BookAdded, Book: *book})
+			} else if book != nil && !reflect.DeepEqual(previous, *book) {
+				events = append(events, BookEvent{Type: BookUpdated, Book: *book})
+			}
+		}
+
+		sorted[namedBook.key] = watched
+		if watched.book != nil {
+			books[watched.title] = *watched.book
+		}
+	}
+
+	for title, book := range w.books {
+		if _, ok := books[title]; !ok {
+			events = append(events, BookEvent{Type: BookRemoved, Book: book})
+		}
+	}
+
+	w.sorted = sorted
+	w.books = books
+	w.changed = make(map[string]struct{})
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Book.Title < events[j].Book.Title
+	})
+
+	for _, event := range events {
+		select {
+		case w.events <- event:
+		case <-w.ctx.Done():
+			return
+		}
+	}
+}
+
+// chaptersChanged reports whether a book's chapters differ from the ones it was last sorted with
+func (w *watcher) chaptersChanged(previous []string, paths []string) bool {
+	if !reflect.DeepEqual(previous, paths) {
+		return true
+	}
+
+	for _, chapterPath := range paths {
+		if _, ok := w.changed[chapterPath]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// chapterPaths returns the paths of the chapters, in order
+func chapterPaths(chapters []RelativeAudioBookChapter) []string {
+	paths := make([]string, len(chapters))
+	for i, chapter := range chapters {
+		paths[i] = chapter.filePath
+	}
+	sort.Strings(paths)
+
+	return paths
+}
@@ -0,0 +1,185 @@
+package scanner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func copyTestFile(t *testing.T, src string, dst string) {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(dst, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func nextBookEvent(t *testing.T, events <-chan BookEvent) BookEvent {
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("Watch() closed its channel early")
+		}
+		return event
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for a book event")
+	}
+
+	return BookEvent{}
+}
+
+func TestWatch(t *testing.T) {
+	root := t.TempDir()
+	copyTestFile(t, "testdata/audiobooks/frankenstein/frankenstein_00_shelley_64kb.mp3", filepath.Join(root, "frankenstein/00.mp3"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	onError := func(err *ScanError) {
+		t.Errorf("Watch() reported an error: %v", err)
+	}
+
+	events, err := Watch(ctx, root, SortByDiscNumber[RelativeAudioBookChapter], WatchOptions{Debounce: 100 * time.Millisecond, OnError: onError})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type step struct {
+		name         string
+		change       func()
+		wantType     BookEventType
+		wantTitle    string
+		wantChapters int
+	}
+	steps := []step{
+		{
+			"Initial Scan",
+			func() {},
+			BookAdded, "Frankenstein", 1,
+		},
+		{
+			"File Added",
+			func() {
+				copyTestFile(t, "testdata/audiobooks/frankenstein/frankenstein_01_shelley_64kb.mp3", filepath.Join(root, "frankenstein/01.mp3"))
+			},
+			BookUpdated, "Frankenstein", 2,
+		},
+		{
+			"Book Added",
+			func() {
+				copyTestFile(t, "testdata/audiobooks/crimeandpunishment/crimepunishment_00_dostoyevsky_64kb.mp3", filepath.Join(root, "crime/and/punishment/00.mp3"))
+			},
+			BookAdded, "Crime and Punishment (Version 3)", 1,
+		},
+		{
+			"File Removed",
+			func() {
+				os.Remove(filepath.Join(root, "frankenstein/00.mp3"))
+			},
+			BookUpdated, "Frankenstein", 1,
+		},
+		{
+			"Book Removed",
+			func() {
+				os.RemoveAll(filepath.Join(root, "crime"))
+			},
+			BookRemoved, "Crime and Punishment (Version 3)", 1,
+		},
+		{
+			"Book Ignored",
+			func() {
+				os.WriteFile(filepath.Join(root, IgnoreFileName), []byte("frankenstein/\n"), 0644)
+			},
+			BookRemoved, "Frankenstein", 1,
+		},
+		{
+			"Book No Longer Ignored",
+			func() {
+				os.Remove(filepath.Join(root, IgnoreFileName))
+			},
+			BookAdded, "Frankenstein", 1,
+		},
+	}
+	for _, tt := range steps {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.change()
+
+			event := nextBookEvent(t, events)
+			if event.Type != tt.wantType || event.Book.Title != tt.wantTitle || len(event.Book.Chapters) != tt.wantChapters {
+				t.Errorf("Got %s event for %s with %d chapters, want %s event for %s with %d chapters",
+					event.Type, event.Book.Title, len(event.Book.Chapters), tt.wantType, tt.wantTitle, tt.wantChapters)
+			}
+		})
+	}
+
+	cancel()
+	for range events {
+		// Drain until the watcher closes the channel
+	}
+}
+
+func TestWatch_SortsChangedBooks(t *testing.T) {
+	root := t.TempDir()
+	copyTestFile(t, "testdata/audiobooks/frankenstein/frankenstein_00_shelley_64kb.mp3", filepath.Join(root, "frankenstein/00.mp3"))
+	copyTestFile(t, "testdata/audiobooks/crimeandpunishment/crimepunishment_00_dostoyevsky_64kb.mp3", filepath.Join(root, "crime/00.mp3"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Counts the times each book is sorted
+	var mutex sync.Mutex
+	sorts := make(map[string]int)
+	sorter := func(chapters []RelativeAudioBookChapter) ([]RelativeAudioBookChapter, []error) {
+		mutex.Lock()
+		sorts[chapters[0].bookTitle]++
+		mutex.Unlock()
+
+		return SortByDiscNumber(chapters)
+	}
+
+	events, err := Watch(ctx, root, sorter, WatchOptions{Debounce: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nextBookEvent(t, events)
+	nextBookEvent(t, events)
+
+	copyTestFile(t, "testdata/audiobooks/frankenstein/frankenstein_01_shelley_64kb.mp3", filepath.Join(root, "frankenstein/01.mp3"))
+	if event := nextBookEvent(t, events); event.Type != BookUpdated || event.Book.Title != "Frankenstein" {
+		t.Fatalf("Got %s event for %s, want %s event for Frankenstein", event.Type, event.Book.Title, BookUpdated)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	want := map[string]int{"Frankenstein": 2, "Crime and Punishment (Version 3)": 1}
+	if !reflect.DeepEqual(sorts, want) {
+		t.Errorf("Books were sorted %v times, want %v", sorts, want)
+	}
+}
+
+func Test_resetTimer(t *testing.T) {
+	// The timer fires without its channel being read
+	timer := time.NewTimer(time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+
+	resetTimer(timer, time.Hour)
+
+	select {
+	case <-timer.C:
+		t.Error("Timer fired straight after being reset")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
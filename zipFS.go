@@ -0,0 +1,428 @@
+package scanner
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/fs"
+	"strings"
+	"sync"
+)
+
+const zipArchiveExtension = ".zip"
+
+// Separates the path of a zip archive from the path of an entry inside it in chapter urls,
+// e.g. books/frankenstein_64kb_mp3.zip!/frankenstein_00_shelley_64kb.mp3
+const zipEntrySeparator = "!/"
+
+// URLFS is implemented by filesystems whose files should be located by something
+// other than their path in the filesystem, like an entry in an archive or a remote url.
+// Scanners record URL(name) as the url of the chapter read from name.
+type URLFS interface {
+	fs.FS
+	URL(name string) string
+}
+
+type zipArchive struct {
+	reader *zip.Reader
+	// Underlying archive bytes, used to read stored entries without decompressing
+	readerAt io.ReaderAt
+	// Closes the archive file, nil if it was buffered in memory
+	closer io.Closer
+	// Entries by path inside the archive
+	files map[string]*zip.File
+	info  fs.FileInfo
+	// Number of users of the archive, like open files in it, see ZipFS.release
+	refs int
+}
+
+// DefaultMaxOpenArchives is the number of archives a ZipFS keeps open while nothing in them is
+const DefaultMaxOpenArchives = 8
+
+// ZipFS wraps a filesystem, presenting every .zip archive in it as a directory
+// so that WalkFS and ScanFS descend into archives without extracting them.  Scans and walks
+// with OpenZipArchives set wrap their filesystems in one.
+// Archives stay open while files in them are, and the most recently used of the
+// rest are kept open to save reading their directories again.  Callers should
+// Close the filesystem when they're done with it, which closes them all.
+type ZipFS struct {
+	// Number of archives kept open while nothing in them is.  If zero, DefaultMaxOpenArchives.
+	MaxOpenArchives int
+
+	base     fs.FS
+	mutex    sync.Mutex
+	archives map[string]*zipArchive
+	// Paths of the open archives nothing is using, least recently used first
+	idle []string
+}
+
+func (z *ZipFS) Initialize(base fs.FS) {
+	z.base = base
+	z.archives = make(map[string]*zipArchive)
+	z.idle = nil
+}
+
+// openZipArchives returns fsys wrapped in a ZipFS, unless it already is one, and a function
+// that closes the archives it opened.  A ZipFS passed in is left for its owner to close.
+func openZipArchives(fsys fs.FS) (fs.FS, func()) {
+	if _, ok := fsys.(*ZipFS); ok {
+		return fsys, func() {}
+	}
+
+	var zipFS ZipFS
+	zipFS.Initialize(fsys)
+
+	return &zipFS, func() { zipFS.Close() }
+}
+
+func isZipArchiveName(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), zipArchiveExtension)
+}
+
+func openZipArchive(fsys fs.FS, archivePath string) (*zipArchive, error) {
+	archiveFile, err := fsys.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := archiveFile.Stat()
+	if err != nil {
+		archiveFile.Close()
+		return nil, err
+	}
+
+	archive := zipArchive{info: info, closer: archiveFile}
+
+	if readerAt, ok := archiveFile.(io.ReaderAt); ok {
+		archive.readerAt = readerAt
+	} else {
+		// Archives on filesystems without random access are buffered in memory
+		archiveBytes, err := io.ReadAll(archiveFile)
+		archiveFile.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		archive.readerAt = bytes.NewReader(archiveBytes)
+		archive.closer = nil
+	}
+
+	archive.reader, err = zip.NewReader(archive.readerAt, info.Size())
+	if err != nil {
+		archive.Close()
+		return nil, err
+	}
+
+	archive.files = make(map[string]*zip.File, len(archive.reader.File))
+	for _, f := range archive.reader.File {
+		archive.files[strings.TrimSuffix(f.Name, "/")] = f
+	}
+
+	return &archive, nil
+}
+
+func (a *zipArchive) Close() error {
+	if a.closer == nil {
+		return nil
+	}
+
+	return a.closer.Close()
+}
+
+// archive returns the archive at archivePath, opening it if it isn't open already.
+// The returned function releases it, so it can be closed.
+func (z *ZipFS) archive(archivePath string) (*zipArchive, func(), error) {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+
+	archive, ok := z.archives[archivePath]
+	if ok {
+		z.removeIdle(archivePath)
+	} else {
+		var err error
+		if archive, err = openZipArchive(z.base, archivePath); err != nil {
+			return nil, nil, err
+		}
+
+		z.archives[archivePath] = archive
+	}
+
+	archive.refs++
+
+	var once sync.Once
+	return archive, func() { once.Do(func() { z.release(archivePath, archive) }) }, nil
+}
+
+// release gives up a reference to the archive, closing the least recently used idle archives
+// if more than MaxOpenArchives are idle
+func (z *ZipFS) release(archivePath string, archive *zipArchive) {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+
+	archive.refs--
+	// The archive was closed and forgotten by Close while it was in use
+	if archive.refs > 0 || z.archives[archivePath] != archive {
+		return
+	}
+
+	z.idle = append(z.idle, archivePath)
+
+	maxOpenArchives := z.MaxOpenArchives
+	if maxOpenArchives <= 0 {
+		maxOpenArchives = DefaultMaxOpenArchives
+	}
+
+	for len(z.idle) > maxOpenArchives {
+		evicted := z.idle[0]
+		z.idle = z.idle[1:]
+
+		z.archives[evicted].Close()
+		delete(z.archives, evicted)
+	}
+}
+
+func (z *ZipFS) isOpen(archivePath string) bool {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+
+	_, ok := z.archives[archivePath]
+	return ok
+}
+
+func (z *ZipFS) removeIdle(archivePath string) {
+	for i, idle := range z.idle {
+		if idle == archivePath {
+			z.idle = append(z.idle[:i], z.idle[i+1:]...)
+			return
+		}
+	}
+}
+
+// splitArchivePath splits name into the path of the archive containing it and the path of the
+// entry inside that archive.  ok is false if name isn't inside (or naming) an archive.
+func (z *ZipFS) splitArchivePath(name string) (archivePath string, entryName string, ok bool) {
+	if name == "." {
+		return "", "", false
+	}
+
+	components := strings.Split(name, "/")
+	for i, component := range components {
+		if !isZipArchiveName(component) {
+			continue
+		}
+
+		// Archives that are already open don't need checking again
+		archivePath = strings.Join(components[:i+1], "/")
+		if !z.isOpen(archivePath) {
+			info, err := fs.Stat(z.base, archivePath)
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+		}
+
+		entryName = strings.Join(components[i+1:], "/")
+		if entryName == "" {
+			entryName = "."
+		}
+
+		return archivePath, entryName, true
+	}
+
+	return "", "", false
+}
+
+func (z *ZipFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	archivePath, entryName, ok := z.splitArchivePath(name)
+	if !ok {
+		return z.base.Open(name)
+	}
+
+	archive, release, err := z.archive(archivePath)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	// Stored entries are handed out as sections of the archive, so they can be seeked
+	// like regular files instead of being read into memory.
+	if f, ok := archive.files[entryName]; ok && f.Method == zip.Store && !f.FileInfo().IsDir() {
+		offset, err := f.DataOffset()
+		if err != nil {
+			release()
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+
+		return &zipStoredFile{
+			SectionReader: io.NewSectionReader(archive.readerAt, offset, int64(f.UncompressedSize64)),
+			info:          f.FileInfo(),
+			release:       release,
+		}, nil
+	}
+
+	file, err := archive.reader.Open(entryName)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	// Directories are listed from the archive's directory, which is already in memory
+	if info, err := file.Stat(); err == nil && info.IsDir() {
+		release()
+		return file, nil
+	}
+
+	return &zipEntryFile{File: file, release: release}, nil
+}
+
+func (z *ZipFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	archivePath, entryName, ok := z.splitArchivePath(name)
+	if ok {
+		archive, release, err := z.archive(archivePath)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+		}
+		defer release()
+
+		return fs.ReadDir(archive.reader, entryName)
+	}
+
+	entries, err := fs.ReadDir(z.base, name)
+
+	for i, entry := range entries {
+		if entry.Type().IsRegular() && isZipArchiveName(entry.Name()) {
+			entries[i] = zipArchiveDirEntry{entry}
+		}
+	}
+
+	return entries, err
+}
+
+func (z *ZipFS) Stat(name string) (fs.FileInfo, error) {
+	archivePath, entryName, ok := z.splitArchivePath(name)
+	if !ok {
+		return fs.Stat(z.base, name)
+	}
+
+	archive, release, err := z.archive(archivePath)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	defer release()
+
+	if entryName == "." {
+		return zipArchiveInfo{archive.info}, nil
+	}
+
+	return fs.Stat(archive.reader, entryName)
+}
+
+// URL returns the url of name, separating the archive and the entry inside it with "!/"
+func (z *ZipFS) URL(name string) string {
+	archivePath, entryName, ok := z.splitArchivePath(name)
+	if !ok {
+		return fileURL(z.base, name)
+	}
+
+	if entryName == "." {
+		return fileURL(z.base, archivePath)
+	}
+
+	return fileURL(z.base, archivePath) + zipEntrySeparator + entryName
+}
+
+func (z *ZipFS) defaultWorkers() int {
+	return DefaultWorkers(z.base)
+}
+
+// Close closes every archive opened by the filesystem, including those with files still open
+func (z *ZipFS) Close() error {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+
+	var firstErr error
+	for archivePath, archive := range z.archives {
+		if err := archive.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+
+		delete(z.archives, archivePath)
+	}
+	z.idle = nil
+
+	return firstErr
+}
+
+// fileURL returns the url of name in fsys, which is its path unless fsys is a URLFS
+func fileURL(fsys fs.FS, name string) string {
+	if urlFS, ok := fsys.(URLFS); ok {
+		return urlFS.URL(name)
+	}
+
+	return name
+}
+
+// Presents a zip archive as a directory in its parent's listing
+type zipArchiveDirEntry struct {
+	fs.DirEntry
+}
+
+func (e zipArchiveDirEntry) IsDir() bool {
+	return true
+}
+
+func (e zipArchiveDirEntry) Type() fs.FileMode {
+	return fs.ModeDir
+}
+
+func (e zipArchiveDirEntry) Info() (fs.FileInfo, error) {
+	info, err := e.DirEntry.Info()
+	if err != nil {
+		return nil, err
+	}
+
+	return zipArchiveInfo{info}, nil
+}
+
+type zipArchiveInfo struct {
+	fs.FileInfo
+}
+
+func (i zipArchiveInfo) Mode() fs.FileMode {
+	return i.FileInfo.Mode().Perm() | fs.ModeDir
+}
+
+func (i zipArchiveInfo) IsDir() bool {
+	return true
+}
+
+type zipStoredFile struct {
+	*io.SectionReader
+	info fs.FileInfo
+	// Releases the archive, see ZipFS.archive
+	release func()
+}
+
+func (f *zipStoredFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *zipStoredFile) Close() error {
+	f.release()
+	return nil
+}
+
+// A compressed entry, which holds its archive open until it's closed
+type zipEntryFile struct {
+	fs.File
+	release func()
+}
+
+func (f *zipEntryFile) Close() error {
+	err := f.File.Close()
+	f.release()
+
+	return err
+}
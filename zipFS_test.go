@@ -0,0 +1,317 @@
+package scanner
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+
+	"github.com/go-test/deep"
+	library "github.com/themooer1/audiobook-library"
+)
+
+// zipFiles builds a zip archive containing the given files from disk, named by their base name
+func zipFiles(t *testing.T, method uint16, filePaths ...string) []byte {
+	var archive bytes.Buffer
+	writer := zip.NewWriter(&archive)
+
+	for _, filePath := range filePaths {
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		entry, err := writer.CreateHeader(&zip.FileHeader{Name: path.Base(filePath), Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := entry.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return archive.Bytes()
+}
+
+// zipTestFS returns a filesystem holding two archives and a file that isn't one
+func zipTestFS(t *testing.T) fstest.MapFS {
+	return fstest.MapFS{
+		"frankenstein_64kb_mp3.zip": {
+			Data: zipFiles(
+				t,
+				zip.Store,
+				"testdata/audiobooks/frankenstein/frankenstein_00_shelley_64kb.mp3",
+				"testdata/audiobooks/frankenstein/frankenstein_01_shelley_64kb.mp3",
+			),
+		},
+		"nested/crimeandpunishment_64kb_mp3.zip": {
+			Data: zipFiles(
+				t,
+				zip.Deflate,
+				"testdata/audiobooks/crimeandpunishment/crimepunishment_00_dostoyevsky_64kb.mp3",
+			),
+		},
+		"notes.txt": {Data: []byte("not an archive")},
+	}
+}
+
+func newTestZipFS(t *testing.T) *ZipFS {
+	var zipFS ZipFS
+	zipFS.Initialize(zipTestFS(t))
+	t.Cleanup(func() { zipFS.Close() })
+
+	return &zipFS
+}
+
+func TestZipFS_Walk(t *testing.T) {
+	zipFS := newTestZipFS(t)
+
+	result := walkTestResult{}
+	result.Initialize("")
+	result.addFile("notes.txt")
+	result.addFile("frankenstein_64kb_mp3.zip/frankenstein_00_shelley_64kb.mp3")
+	result.addFile("frankenstein_64kb_mp3.zip/frankenstein_01_shelley_64kb.mp3")
+	result.addFile("nested/crimeandpunishment_64kb_mp3.zip/crimepunishment_00_dostoyevsky_64kb.mp3")
+
+	fileFilter := func(path string, dirEntry fs.DirEntry) (bool, error) {
+		return !dirEntry.IsDir(), nil
+	}
+
+	WalkFS(zipFS, fileFilter, result.getFileHandler(t), result.getErrorHandler(t))
+
+	if !result.isSuccessful() {
+		t.Errorf("Files not walked: %v", result.files)
+	}
+}
+
+func TestWalkContext_OpenZipArchives(t *testing.T) {
+	base := &openArchiveCountingFS{MapFS: zipTestFS(t)}
+
+	result := walkTestResult{}
+	result.Initialize("")
+	result.addFile("notes.txt")
+	result.addFile("frankenstein_64kb_mp3.zip/frankenstein_00_shelley_64kb.mp3")
+	result.addFile("frankenstein_64kb_mp3.zip/frankenstein_01_shelley_64kb.mp3")
+	result.addFile("nested/crimeandpunishment_64kb_mp3.zip/crimepunishment_00_dostoyevsky_64kb.mp3")
+
+	fileFilter := func(path string, dirEntry fs.DirEntry) (bool, error) {
+		return !dirEntry.IsDir(), nil
+	}
+
+	WalkContext(context.Background(), base, fileFilter, result.getFileHandler(t), result.getErrorHandler(t), WalkOptions{OpenZipArchives: true})
+
+	if !result.isSuccessful() {
+		t.Errorf("Files not walked: %v", result.files)
+	}
+
+	if base.open != 0 {
+		t.Errorf("%d archives were left open after the walk", base.open)
+	}
+}
+
+func TestZipFS_URL(t *testing.T) {
+	zipFS := newTestZipFS(t)
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"notes.txt", "notes.txt"},
+		{"frankenstein_64kb_mp3.zip", "frankenstein_64kb_mp3.zip"},
+		{"frankenstein_64kb_mp3.zip/frankenstein_00_shelley_64kb.mp3", "frankenstein_64kb_mp3.zip!/frankenstein_00_shelley_64kb.mp3"},
+		{"nested/crimeandpunishment_64kb_mp3.zip/crimepunishment_00_dostoyevsky_64kb.mp3", "nested/crimeandpunishment_64kb_mp3.zip!/crimepunishment_00_dostoyevsky_64kb.mp3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := zipFS.URL(tt.name); got != tt.want {
+				t.Errorf("ZipFS.URL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanFS_Zip(t *testing.T) {
+	zipFS := newTestZipFS(t)
+
+	lib := library.AudioBookLibrary{}
+	lib.Initialize()
+
+	errors := ScanFS(zipFS, &lib, SortByDiscNumber[RelativeAudioBookChapter])
+	if len(errors) > 0 {
+		t.Errorf("ScanFS() returned errors: %v", errors)
+	}
+
+	want := map[string]library.AudioBook{
+		"Frankenstein": {
+			Title:       "Frankenstein",
+			Author:      "Mary W. Shelley",
+			Description: "Description not available",
+			Chapters: []library.AudioBookChapter{
+				{Title: "00 - Letters", Index: 0, Url: "frankenstein_64kb_mp3.zip!/frankenstein_00_shelley_64kb.mp3"},
+				{Title: "01 - Chapter 1", Index: 1, Url: "frankenstein_64kb_mp3.zip!/frankenstein_01_shelley_64kb.mp3"},
+			},
+		},
+		"Crime and Punishment (Version 3)": {
+			Title:       "Crime and Punishment (Version 3)",
+			Author:      "Fyodor Dostoyevsky",
+			Description: "Description not available",
+			Chapters: []library.AudioBookChapter{
+				{Title: "00 - Preface", Index: 0, Url: "nested/crimeandpunishment_64kb_mp3.zip!/crimepunishment_00_dostoyevsky_64kb.mp3"},
+			},
+		},
+	}
+
+	if diff := deep.Equal(want, lib.AudioBooksByName); diff != nil {
+		for _, d := range diff {
+			t.Error(d)
+		}
+	}
+}
+
+func TestScanContext_OpenZipArchives(t *testing.T) {
+	base := &openArchiveCountingFS{MapFS: zipTestFS(t)}
+
+	lib := library.AudioBookLibrary{}
+	lib.Initialize()
+
+	errors := ScanContext(context.Background(), base, &lib, SortByDiscNumber[RelativeAudioBookChapter], ScanOptions{OpenZipArchives: true})
+	if len(errors) > 0 {
+		t.Errorf("ScanContext() returned errors: %v", errors)
+	}
+
+	var urls []string
+	for _, book := range lib.AudioBooksByName {
+		for _, chapter := range book.Chapters {
+			urls = append(urls, chapter.Url)
+		}
+	}
+	sort.Strings(urls)
+
+	want := []string{
+		"frankenstein_64kb_mp3.zip!/frankenstein_00_shelley_64kb.mp3",
+		"frankenstein_64kb_mp3.zip!/frankenstein_01_shelley_64kb.mp3",
+		"nested/crimeandpunishment_64kb_mp3.zip!/crimepunishment_00_dostoyevsky_64kb.mp3",
+	}
+	if diff := deep.Equal(want, urls); diff != nil {
+		t.Error(diff)
+	}
+
+	// The archives are closed with the scan
+	if base.open != 0 {
+		t.Errorf("%d archives were left open after the scan", base.open)
+	}
+}
+
+// Counts stats in the wrapped filesystem
+type statCountingFS struct {
+	fstest.MapFS
+	stats []string
+}
+
+func (s *statCountingFS) Stat(name string) (fs.FileInfo, error) {
+	s.stats = append(s.stats, name)
+	return s.MapFS.Stat(name)
+}
+
+func TestZipFS_StatsOnlyArchives(t *testing.T) {
+	base := &statCountingFS{MapFS: zipTestFS(t)}
+
+	var zipFS ZipFS
+	zipFS.Initialize(base)
+	defer zipFS.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, err := zipFS.Stat("nested/crimeandpunishment_64kb_mp3.zip/crimepunishment_00_dostoyevsky_64kb.mp3"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only the archive is checked, and only until it's open
+	if want := []string{"nested/crimeandpunishment_64kb_mp3.zip"}; !reflect.DeepEqual(base.stats, want) {
+		t.Errorf("Stat() was called with %v, want %v", base.stats, want)
+	}
+}
+
+// Counts the archives open in the wrapped filesystem
+type openArchiveCountingFS struct {
+	fstest.MapFS
+	open int32
+}
+
+type openArchiveCountingFile struct {
+	fs.File
+	io.ReaderAt
+	fsys   *openArchiveCountingFS
+	closed bool
+}
+
+func (c *openArchiveCountingFS) Open(name string) (fs.File, error) {
+	f, err := c.MapFS.Open(name)
+	if err != nil || !isZipArchiveName(name) {
+		return f, err
+	}
+
+	atomic.AddInt32(&c.open, 1)
+	return &openArchiveCountingFile{File: f, ReaderAt: f.(io.ReaderAt), fsys: c}, nil
+}
+
+func (f *openArchiveCountingFile) Close() error {
+	if !f.closed {
+		f.closed = true
+		atomic.AddInt32(&f.fsys.open, -1)
+	}
+
+	return f.File.Close()
+}
+
+func TestZipFS_MaxOpenArchives(t *testing.T) {
+	archive := zipFiles(t, zip.Deflate, "testdata/audiobooks/frankenstein/frankenstein_00_shelley_64kb.mp3")
+
+	base := &openArchiveCountingFS{MapFS: fstest.MapFS{}}
+	for i := 0; i < 5; i++ {
+		base.MapFS[fmt.Sprintf("copy%d/frankenstein.zip", i)] = &fstest.MapFile{Data: archive}
+	}
+
+	var zipFS ZipFS
+	zipFS.Initialize(base)
+	zipFS.MaxOpenArchives = 2
+
+	var report ScanReport
+	lib := library.AudioBookLibrary{}
+	lib.Initialize()
+
+	errors := ScanContext(context.Background(), &zipFS, &lib, SortByDiscNumber[RelativeAudioBookChapter], ScanOptions{Grouper: GroupByDirectory, Report: &report})
+	if len(errors) > 0 {
+		t.Errorf("ScanContext() returned errors: %v", errors)
+	}
+
+	if len(report.Files) != 5 {
+		t.Errorf("Read %d files, want 5", len(report.Files))
+	}
+
+	if open := atomic.LoadInt32(&base.open); open > 2 {
+		t.Errorf("%d archives are open after the scan, want at most 2", open)
+	}
+
+	if err := zipFS.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if open := atomic.LoadInt32(&base.open); open != 0 {
+		t.Errorf("%d archives are open after Close, want 0", open)
+	}
+}